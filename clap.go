@@ -2,12 +2,16 @@ package clap
 
 import (
 	"bytes"
+	"encoding"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"reflect"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -16,22 +20,143 @@ import (
 	"github.com/tobiashort/cfmt-go"
 )
 
-var (
+// Parser holds the program name, description, example, output writers and
+// settings used to parse the command line. The zero value is not usable, use
+// New to create a Parser.
+type Parser struct {
 	prog        string
 	desc        string
 	example     string
+	stdout      io.Writer
+	stderr      io.Writer
+	equalsHelp  bool
+	allErrors   bool
+	abbrev      bool
+	respFiles   bool
+	posix       PosixMode
 	parseCalled bool
+}
+
+// PosixMode controls whether options are still recognized after the first
+// positional argument.
+type PosixMode int
+
+const (
+	// PosixOff allows options and positional arguments in any order.
+	PosixOff PosixMode = iota
+	// PosixOn treats everything after the first positional argument as
+	// positional arguments, as if -- was given before it.
+	PosixOn
+	// PosixAuto behaves like PosixOn if the POSIXLY_CORRECT environment
+	// variable is set and like PosixOff otherwise.
+	PosixAuto
 )
 
-func init() {
-	prog = filepath.Base(os.Args[0])
+// Option configures a Parser created by New.
+type Option func(*Parser)
+
+// WithProg sets the program name shown in the help message.
+func WithProg(s string) Option {
+	return func(p *Parser) { p.prog = s }
+}
+
+// WithDescription sets the description shown in the help message.
+func WithDescription(s string) Option {
+	return func(p *Parser) { p.desc = s }
+}
+
+// WithExample sets the example shown in the help message.
+func WithExample(s string) Option {
+	return func(p *Parser) { p.example = s }
+}
+
+// WithStdout sets the writer the help message is printed to when requested.
+func WithStdout(w io.Writer) Option {
+	return func(p *Parser) { p.stdout = w }
+}
+
+// WithStderr sets the writer errors are printed to by Parse.
+func WithStderr(w io.Writer) Option {
+	return func(p *Parser) { p.stderr = w }
+}
+
+// WithEqualsHelp makes the help message render values of long options as
+// --long=<Value> instead of --long <Value>.
+func WithEqualsHelp() Option {
+	return func(p *Parser) { p.equalsHelp = true }
+}
+
+// WithAllErrors makes the parser continue after the first user error and
+// return all of them joined in a single UserError.
+func WithAllErrors() Option {
+	return func(p *Parser) { p.allErrors = true }
+}
+
+// WithAbbreviations allows long options to be abbreviated to any unique
+// prefix, e.g. --verb for --verbose.
+func WithAbbreviations() Option {
+	return func(p *Parser) { p.abbrev = true }
+}
+
+// WithResponseFiles makes the parser replace each @path argument by the
+// arguments read from path. Arguments in the file are separated by whitespace
+// and may be quoted like in a shell. Lines starting with # are comments. Use
+// @@ to pass an argument starting with a literal @.
+func WithResponseFiles() Option {
+	return func(p *Parser) { p.respFiles = true }
+}
+
+// WithPosix sets the PosixMode of the parser. Cmds are not affected, options
+// after a cmd are parsed by the cmd.
+func WithPosix(mode PosixMode) Option {
+	return func(p *Parser) { p.posix = mode }
+}
+
+// New creates a Parser. The program name defaults to the base name of
+// os.Args[0] and the output writers default to os.Stdout and os.Stderr.
+func New(opts ...Option) *Parser {
+	prog := filepath.Base(os.Args[0])
 	prog = strings.TrimSuffix(prog, filepath.Ext(prog))
+	p := &Parser{
+		prog:   prog,
+		stdout: os.Stdout,
+		stderr: os.Stderr,
+	}
+	for _, opt := range opts {
+		opt(p)
+	}
+	return p
+}
+
+var defaultParser = New()
+
+// Enum is implemented by named types whose values are restricted to a fixed
+// set, e.g. a Format string type with the values json, yaml and table. It has
+// the same effect as the choices tag.
+type Enum interface {
+	Choices() []string
+}
+
+// Value is the interface to the value of a custom argument type. It is
+// compatible with flag.Value, so types written for the flag package can be
+// used as fields as is. Set is called for every occurrence of the argument,
+// so a Value may be given multiple times.
+//
+// If the Value also has a Type() string method, its result is shown as the
+// placeholder of the value in the help message. If the Value has an
+// IsBool() bool method returning true, the argument takes no value, like a
+// bool, and Set is called with "true".
+type Value interface {
+	Set(string) error
+	String() string
 }
 
 type arg struct {
 	name          string
 	type_         reflect.Type
 	kind          reflect.Kind
+	pointer       bool
+	custom        bool
 	short         string
 	long          string
 	conflictsWith []string
@@ -39,10 +164,143 @@ type arg struct {
 	positional    bool
 	cmd           bool
 	cmdopt        bool
+	negatable     bool
+	count         bool
+	uniqueKeys    bool
+	trailing      bool
+	hidden        bool
+	deprecated    string
+	aliases       []alias
+	sep           string
+	optionalValue string
+	choices       []string
+	layout        string
+	tz            string
 	desc          string
 	defaultValue  string
 }
 
+// allChoices returns the values allowed for arg, or for its elements if it is
+// a slice, given either by the choices tag or by an Enum type.
+func (arg arg) allChoices() []string {
+	if len(arg.choices) > 0 {
+		return arg.choices
+	}
+	t := arg.type_
+	if arg.isSlice() {
+		t = t.Elem()
+	}
+	if enum, ok := reflect.New(t).Interface().(Enum); ok {
+		return enum.Choices()
+	}
+	return nil
+}
+
+// isSlice reports whether arg is a slice that is filled element by element, as
+// opposed to a custom type with a slice kind like net.IP.
+func (arg arg) isSlice() bool {
+	return arg.kind == reflect.Slice && !arg.custom
+}
+
+// isMap reports whether arg is a map that is filled entry by entry, as opposed
+// to a custom type with a map kind.
+func (arg arg) isMap() bool {
+	return arg.kind == reflect.Map && !arg.custom
+}
+
+// isBool reports whether arg is a plain bool, as opposed to a custom type with
+// a bool kind.
+func (arg arg) isBool() bool {
+	return arg.kind == reflect.Bool && !arg.custom
+}
+
+// takesValue reports whether the non-positional arg is followed by a value.
+// A Value only takes no value if its IsBool method says so.
+func (arg arg) takesValue() bool {
+	if isValue(arg.type_) {
+		return !isBoolValue(arg.type_)
+	}
+	return !arg.isBool() && !arg.count
+}
+
+// valueName returns the placeholder of the value of arg in the help message.
+func (arg arg) valueName() string {
+	if isValue(arg.type_) {
+		if typer, ok := reflect.New(arg.type_).Interface().(interface{ Type() string }); ok && typer.Type() != "" {
+			return typer.Type()
+		}
+	}
+	return arg.name
+}
+
+// defaultElems returns the elements of the default value of a slice, or the
+// key=value pairs of the default value of a map.
+func (arg arg) defaultElems() []string {
+	return splitValue(arg.defaultValue, sliceDefaultSep(arg.sep))
+}
+
+// defaultString returns the default value as shown in the help message.
+func (arg arg) defaultString() string {
+	if arg.isSlice() {
+		elems := arg.defaultElems()
+		for i, elem := range elems {
+			elems[i] = formatText(arg.type_.Elem(), elem)
+		}
+		return strings.Join(elems, ", ")
+	} else if arg.isMap() {
+		return strings.Join(arg.defaultElems(), ", ")
+	}
+	return formatText(arg.type_, arg.defaultValue)
+}
+
+type alias struct {
+	name   string
+	short  bool
+	hidden bool
+}
+
+// longNames returns the long name and the long aliases of arg. Hidden aliases
+// are only included if hidden is true.
+func (arg arg) longNames(hidden bool) []string {
+	names := make([]string, 0)
+	if arg.long != "" {
+		names = append(names, arg.long)
+	}
+	for _, alias := range arg.aliases {
+		if !alias.short && (hidden || !alias.hidden) {
+			names = append(names, alias.name)
+		}
+	}
+	return names
+}
+
+// negatedLongNames returns the --no- forms of the long names of a negatable
+// arg.
+func (arg arg) negatedLongNames(hidden bool) []string {
+	names := make([]string, 0)
+	if arg.negatable {
+		for _, long := range arg.longNames(hidden) {
+			names = append(names, "no-"+long)
+		}
+	}
+	return names
+}
+
+// shortNames returns the short name and the short aliases of arg. Hidden
+// aliases are only included if hidden is true.
+func (arg arg) shortNames(hidden bool) []string {
+	names := make([]string, 0)
+	if arg.short != "" {
+		names = append(names, arg.short)
+	}
+	for _, alias := range arg.aliases {
+		if alias.short && (hidden || !alias.hidden) {
+			names = append(names, alias.name)
+		}
+	}
+	return names
+}
+
 func (arg arg) String() string {
 	if arg.positional {
 		return arg.name
@@ -62,66 +320,314 @@ func (arg arg) String() string {
 	}
 }
 
-type userError struct {
-	msg  string
-	args []arg
+type givenArg struct {
+	arg
+	flag  string
+	index int
 }
 
-func (err userError) Error() string {
-	return err.msg
+// ErrHelp is returned by ParseArgs when -h or --help was given. The help
+// message has already been printed when it is returned.
+var ErrHelp = errors.New("help requested")
+
+// UserError is returned when the command line given by the user cannot be
+// parsed. It wraps one of the more specific error types below, which can be
+// retrieved with errors.As.
+type UserError struct {
+	err    error
+	args   []arg
+	parser *Parser
+}
+
+func (err UserError) Error() string {
+	return err.err.Error()
+}
+
+func (err UserError) Unwrap() error {
+	return err.err
+}
+
+// UnknownArgumentError is returned when an option is not known. Flag is the
+// option as spelled by the user and Index its position in the arguments.
+// Suggestion is the closest known option, or empty if there is none.
+type UnknownArgumentError struct {
+	Flag       string
+	Index      int
+	Suggestion string
+}
+
+func (err UnknownArgumentError) Error() string {
+	if err.Suggestion != "" {
+		return fmt.Sprintf("unknown argument: %s, did you mean %s?", err.Flag, err.Suggestion)
+	}
+	return "unknown argument: " + err.Flag
+}
+
+// MissingValueError is returned when an option that takes a value is the last
+// argument.
+type MissingValueError struct {
+	Field string
+	Flag  string
+	Index int
+}
+
+func (err MissingValueError) Error() string {
+	return "missing value for: " + err.Flag
+}
+
+// InvalidValueError is returned when a value cannot be converted to the type
+// of its field. Flag is empty for positional arguments and Index is -1 for
+// default values. Err describes why the value is invalid.
+type InvalidValueError struct {
+	Field string
+	Flag  string
+	Value string
+	Index int
+	Err   error
+}
+
+func (err InvalidValueError) Error() string {
+	name := err.Flag
+	if name == "" {
+		name = err.Field
+	}
+	var choiceErr InvalidChoiceError
+	if errors.As(err.Err, &choiceErr) {
+		return fmt.Sprintf("invalid value '%s' for %s, %s", choiceErr.Value, name, choiceErr)
+	}
+	return fmt.Sprintf("invalid value for %s: %v", name, err.Err)
+}
+
+// InvalidChoiceError is the Err of an InvalidValueError when a value is not
+// one of the choices of its field. Suggestion is the closest choice, if any.
+type InvalidChoiceError struct {
+	Value      string
+	Choices    []string
+	Suggestion string
+}
+
+func (err InvalidChoiceError) Error() string {
+	msg := "expected one of: " + strings.Join(err.Choices, ", ")
+	if err.Suggestion != "" {
+		msg += fmt.Sprintf(", did you mean '%s'?", err.Suggestion)
+	}
+	return msg
+}
+
+func (err InvalidValueError) Unwrap() error {
+	return err.Err
+}
+
+// ConflictError is returned when two arguments are given that conflict with
+// each other.
+type ConflictError struct {
+	Field            string
+	Flag             string
+	Index            int
+	ConflictingField string
+	ConflictingFlag  string
+	ConflictingIndex int
+}
+
+func (err ConflictError) Error() string {
+	return fmt.Sprintf("conflicting arguments: %s, %s", err.Flag, err.ConflictingFlag)
+}
+
+// MissingMandatoryError is returned when a mandatory argument is not given.
+// Flag is empty for positional arguments.
+type MissingMandatoryError struct {
+	Field      string
+	Flag       string
+	Positional bool
+}
+
+func (err MissingMandatoryError) Error() string {
+	if err.Positional {
+		return "missing mandatory positional argument: " + err.Field
+	}
+	return "missing mandatory argument: " + err.Flag
+}
+
+// MultipleUseError is returned when an argument that is not a slice is given
+// more than once. Index is the position of the repeated use.
+type MultipleUseError struct {
+	Field string
+	Flag  string
+	Index int
+}
+
+func (err MultipleUseError) Error() string {
+	return "multiple use of argument " + err.Flag
+}
+
+// AmbiguousArgumentError is returned when an abbreviated long option matches
+// more than one option. Candidates are the matching options.
+type AmbiguousArgumentError struct {
+	Flag       string
+	Index      int
+	Candidates []string
+}
+
+func (err AmbiguousArgumentError) Error() string {
+	return fmt.Sprintf("ambiguous option: %s, could be %s", err.Flag, strings.Join(err.Candidates, " or "))
+}
+
+// UnknownCommandError is returned when the value given for a cmd does not
+// match any of its cmdopts. Suggestion is the closest cmdopt, or empty if
+// there is none.
+type UnknownCommandError struct {
+	Value      string
+	Index      int
+	Suggestion string
+}
+
+func (err UnknownCommandError) Error() string {
+	if err.Suggestion != "" {
+		return fmt.Sprintf("unknown cmd: %s, did you mean '%s'?", err.Value, err.Suggestion)
+	}
+	return "unknown cmd: " + err.Value
+}
+
+// ResponseFileError is returned when a response file cannot be read or
+// parsed. Line is 0 if the error does not relate to a specific line.
+type ResponseFileError struct {
+	File string
+	Line int
+	Err  error
+}
+
+func (err ResponseFileError) Error() string {
+	if err.Line > 0 {
+		return fmt.Sprintf("%s:%d: %v", err.File, err.Line, err.Err)
+	}
+	return fmt.Sprintf("%s: %v", err.File, err.Err)
+}
+
+func (err ResponseFileError) Unwrap() error {
+	return err.Err
+}
+
+// TooManyArgumentsError is returned when more positional arguments are given
+// than declared.
+type TooManyArgumentsError struct {
+	Value string
+	Index int
 }
 
-type developerError struct {
+func (err TooManyArgumentsError) Error() string {
+	return "too many arguments"
+}
+
+// DeveloperError is returned when the struct passed to ParseArgs is not a
+// valid argument definition, e.g. because of an unknown tag value.
+type DeveloperError struct {
 	msg string
 }
 
-func (err developerError) Error() string {
+func (err DeveloperError) Error() string {
 	return err.msg
 }
 
+func (p *Parser) Prog(s string) {
+	p.prog = s
+}
+
+func (p *Parser) Description(s string) {
+	if p.parseCalled {
+		panic(developerErr("Description must be called before Parse"))
+	}
+	p.desc = s
+}
+
+func (p *Parser) Example(s string) {
+	if p.parseCalled {
+		panic(developerErr("Example must be called before Parse"))
+	}
+	p.example = s
+}
+
+// Parse parses os.Args into strct, which must be a pointer to a struct. On
+// invalid input it prints the error and the help message to stderr and exits
+// the program.
+func (p *Parser) Parse(strct any) {
+	p.parseCalled = true
+	err := p.ParseArgs(os.Args[1:], strct)
+	if err == nil {
+		return
+	}
+	if errors.Is(err, ErrHelp) {
+		os.Exit(0)
+	}
+	var userErr UserError
+	if errors.As(err, &userErr) {
+		fmt.Fprintln(p.stderr, userErr.Error())
+		if userErr.args != nil {
+			fmt.Fprint(p.stderr, "\n")
+			userErr.parser.printHelp(userErr.args, p.stderr)
+			fmt.Fprint(p.stderr, "\n")
+		}
+		os.Exit(1)
+	}
+	panic(err)
+}
+
+// ParseArgs parses args into strct, which must be a pointer to a struct. args
+// must not include the program name. The returned error is a UserError if the
+// arguments are invalid, a DeveloperError if strct is not a valid argument
+// definition, or ErrHelp if help was requested.
+func (p *Parser) ParseArgs(args []string, strct any) error {
+	if p.respFiles {
+		expanded, err := expandResponseFiles(args)
+		if err != nil {
+			return err
+		}
+		args = expanded
+	}
+	return p.parse(append([]string{p.prog}, args...), 0, strct)
+}
+
+// MustParse is like ParseArgs but panics if an error occurs.
+func (p *Parser) MustParse(args []string, strct any) {
+	err := p.ParseArgs(args, strct)
+	if err != nil {
+		panic(err)
+	}
+}
+
 func Prog(s string) {
-	prog = s
+	defaultParser.Prog(s)
 }
 
 func Description(s string) {
-	if parseCalled {
-		userErr("Description must be called before Parse", nil)
-	}
-	desc = s
+	defaultParser.Description(s)
 }
 
 func Example(s string) {
-	if parseCalled {
-		userErr("Example must be called before Parse", nil)
-	}
-	example = s
+	defaultParser.Example(s)
 }
 
 func Parse(strct any) {
+	defaultParser.Parse(strct)
+}
+
+func ParseArgs(args []string, strct any) error {
+	return defaultParser.ParseArgs(args, strct)
+}
+
+func MustParse(args []string, strct any) {
+	defaultParser.MustParse(args, strct)
+}
+
+func (p *Parser) parse(osArgs []string, start int, strct any) (err error) {
 	defer func() {
-		r := recover()
-		if r != nil {
-			switch err := r.(type) {
-			case userError:
-				fmt.Fprintln(os.Stderr, err.Error())
-				if err.args != nil {
-					fmt.Fprint(os.Stderr, "\n")
-					printHelp(err.args, os.Stderr)
-					fmt.Fprint(os.Stderr, "\n")
-				}
-				os.Exit(1)
-			default:
-				panic(r)
-			}
+		if userErr, ok := err.(UserError); ok && userErr.parser == nil {
+			userErr.parser = p
+			err = userErr
 		}
 	}()
-	parseCalled = true
-	parse(os.Args, strct)
-}
 
-func parse(osArgs []string, strct any) {
 	if !isStructPointer(strct) {
-		developerErr("expected struct pointer")
+		return developerErr("expected struct pointer")
 	}
 
 	strctType := reflect.TypeOf(strct).Elem()
@@ -139,8 +645,20 @@ func parse(osArgs []string, strct any) {
 			positional    = false
 			cmd           = false
 			cmdopt        = false
+			negatable     = false
+			count         = false
+			uniqueKeys    = false
+			trailing      = false
+			hidden        = false
+			deprecated    = ""
+			aliases       = make([]alias, 0)
+			sep           = ""
+			optionalValue = ""
+			choices       = make([]string, 0)
+			layout        = ""
+			tz            = ""
 			desc          = ""
-			defaultValue  = ""
+			defaultValues = make([]string, 0)
 		)
 
 		tag := field.Tag.Get("clap")
@@ -155,7 +673,33 @@ func parse(osArgs []string, strct any) {
 				} else if strings.HasPrefix(tagValue, "conflicts=") {
 					conflictsWith = strings.Split(strings.Split(tagValue, "=")[1], ",")
 				} else if strings.HasPrefix(tagValue, "default=") {
-					defaultValue = strings.Split(tagValue, "=")[1]
+					defaultValues = append(defaultValues, strings.SplitN(tagValue, "=", 2)[1])
+				} else if strings.HasPrefix(tagValue, "alias=") {
+					for _, name := range strings.Split(strings.Split(tagValue, "=")[1], ",") {
+						aliases = append(aliases, alias{name: name})
+					}
+				} else if strings.HasPrefix(tagValue, "short_alias=") {
+					for _, name := range strings.Split(strings.Split(tagValue, "=")[1], ",") {
+						aliases = append(aliases, alias{name: name, short: true})
+					}
+				} else if strings.HasPrefix(tagValue, "hidden_alias=") {
+					for _, name := range strings.Split(strings.Split(tagValue, "=")[1], ",") {
+						aliases = append(aliases, alias{name: name, hidden: true})
+					}
+				} else if strings.HasPrefix(tagValue, "hidden_short_alias=") {
+					for _, name := range strings.Split(strings.Split(tagValue, "=")[1], ",") {
+						aliases = append(aliases, alias{name: name, short: true, hidden: true})
+					}
+				} else if strings.HasPrefix(tagValue, "sep=") {
+					sep = strings.SplitN(tagValue, "=", 2)[1]
+				} else if strings.HasPrefix(tagValue, "choices=") {
+					choices = strings.Split(strings.SplitN(tagValue, "=", 2)[1], "|")
+				} else if strings.HasPrefix(tagValue, "layout=") {
+					layout = strings.SplitN(tagValue, "=", 2)[1]
+				} else if strings.HasPrefix(tagValue, "tz=") {
+					tz = strings.SplitN(tagValue, "=", 2)[1]
+				} else if strings.HasPrefix(tagValue, "optional_value=") {
+					optionalValue = strings.SplitN(tagValue, "=", 2)[1]
 				} else if strings.HasPrefix(tagValue, "desc=") {
 					desc = strings.Split(tagValue, "=")[1]
 				} else if tagValue == "mandatory" {
@@ -168,8 +712,20 @@ func parse(osArgs []string, strct any) {
 				} else if tagValue == "cmdopt" {
 					cmdopt = true
 					positional = true
+				} else if tagValue == "negatable" {
+					negatable = true
+				} else if tagValue == "count" {
+					count = true
+				} else if tagValue == "unique_keys" {
+					uniqueKeys = true
+				} else if tagValue == "trailing" {
+					trailing = true
+				} else if tagValue == "hidden" {
+					hidden = true
+				} else if strings.HasPrefix(tagValue, "deprecated=") {
+					deprecated = strings.SplitN(tagValue, "=", 2)[1]
 				} else {
-					developerErr(fmt.Sprintf("unknown tag value: %s. Valid tage values are: short, long, conflicts, default, desc, mandatory, positional, cmd, cmdopt.", tagValue))
+					return developerErr(fmt.Sprintf("unknown tag value: %s. Valid tage values are: short, long, alias, short_alias, hidden_alias, hidden_short_alias, conflicts, default, sep, choices, layout, tz, optional_value, desc, mandatory, positional, cmd, cmdopt, negatable, count, unique_keys, trailing, hidden, deprecated.", tagValue))
 				}
 			}
 		}
@@ -179,10 +735,29 @@ func parse(osArgs []string, strct any) {
 			long = ""
 		}
 
+		// Pointer fields stay nil unless the argument is given or has a
+		// default value, the value is parsed as the type pointed to.
+		fieldType := field.Type
+		pointer := fieldType.Kind() == reflect.Pointer
+		if pointer {
+			fieldType = fieldType.Elem()
+		}
+
+		// Custom types are parsed as a whole, even if their kind is a slice
+		// or a map.
+		custom := isValue(fieldType) || isTextUnmarshaler(fieldType)
+
+		if len(defaultValues) > 1 && (custom || fieldType.Kind() != reflect.Slice && fieldType.Kind() != reflect.Map) {
+			return developerErr("only slice and map arguments can have multiple default values: " + field.Name)
+		}
+		defaultValue := strings.Join(defaultValues, sliceDefaultSep(sep))
+
 		programArgs = append(programArgs, arg{
 			name:          field.Name,
-			type_:         field.Type,
-			kind:          field.Type.Kind(),
+			type_:         fieldType,
+			kind:          fieldType.Kind(),
+			pointer:       pointer,
+			custom:        custom,
 			long:          long,
 			short:         short,
 			conflictsWith: conflictsWith,
@@ -190,6 +765,18 @@ func parse(osArgs []string, strct any) {
 			positional:    positional,
 			cmd:           cmd,
 			cmdopt:        cmdopt,
+			negatable:     negatable,
+			count:         count,
+			uniqueKeys:    uniqueKeys,
+			trailing:      trailing,
+			hidden:        hidden,
+			deprecated:    deprecated,
+			aliases:       aliases,
+			sep:           sep,
+			optionalValue: optionalValue,
+			choices:       choices,
+			layout:        layout,
+			tz:            tz,
 			desc:          desc,
 			defaultValue:  defaultValue,
 		})
@@ -205,93 +792,261 @@ func parse(osArgs []string, strct any) {
 	}
 
 	programArgs = append(programArgs, implicitHelpArg)
+
+	// --help-all is only added if there is something hidden to reveal.
+	hasHelpAll := slices.ContainsFunc(programArgs, func(arg arg) bool {
+		return arg.hidden || slices.ContainsFunc(arg.aliases, func(alias alias) bool { return alias.hidden })
+	})
+	if hasHelpAll {
+		implicitHelpAllArg := arg{
+			name:  "HelpAll",
+			type_: reflect.TypeOf(true),
+			kind:  reflect.Bool,
+			long:  "help-all",
+			desc:  "Show this help message including hidden options and exit",
+		}
+		programArgs = append(programArgs, implicitHelpAllArg)
+	}
+
 	programNonPositionalArgs := filterArgs(programArgs, func(arg arg) bool { return !arg.positional })
 	programPositionalArgs := filterArgs(programArgs, func(arg arg) bool { return arg.positional })
 
-	checkForNameCollisions(programArgs)
-	checkForMandatoryArgsWithDefaultValue(programArgs)
-	checkForSlicesWithDefaultValue(programArgs)
-	checkForEitherLongOrShortGiven(programNonPositionalArgs)
-	checkForInvalidPositionalArguments(programPositionalArgs)
+	if err := checkForInvalidAliases(programArgs); err != nil {
+		return err
+	}
+	if err := checkForInvalidDeprecatedArgs(programArgs); err != nil {
+		return err
+	}
+	if err := checkForInvalidTrailingArgs(programArgs); err != nil {
+		return err
+	}
+	if err := checkForInvalidMapArgs(programArgs); err != nil {
+		return err
+	}
+	if err := checkForInvalidPointerArgs(programArgs); err != nil {
+		return err
+	}
+	if err := checkForInvalidChoicesArgs(programArgs); err != nil {
+		return err
+	}
+	if err := checkForInvalidTimeArgs(programArgs); err != nil {
+		return err
+	}
+	if err := checkForNameCollisions(programArgs); err != nil {
+		return err
+	}
+	if err := checkForMandatoryArgsWithDefaultValue(programArgs); err != nil {
+		return err
+	}
+	if err := checkForEitherLongOrShortGiven(programNonPositionalArgs); err != nil {
+		return err
+	}
+	if err := checkForInvalidPositionalArguments(programPositionalArgs); err != nil {
+		return err
+	}
+	if err := checkForInvalidNegatableArgs(programArgs); err != nil {
+		return err
+	}
+	if err := checkForInvalidOptionalValueArgs(programArgs); err != nil {
+		return err
+	}
+	if err := checkForInvalidCountArgs(programArgs); err != nil {
+		return err
+	}
+	if err := checkForInvalidSepArgs(programArgs); err != nil {
+		return err
+	}
 
-	givenNonPositionalArgs := make([]arg, 0)
+	givenNonPositionalArgs := make([]givenArg, 0)
 	givenPositionalArgs := make([]arg, 0)
 
+	// report returns err if parsing should stop. With WithAllErrors, user
+	// errors are collected instead and nil is returned.
+	userErrs := make([]error, 0)
+	report := func(err error) error {
+		userErr, ok := err.(UserError)
+		if !p.allErrors || !ok {
+			return err
+		}
+		if userErr.parser == nil {
+			userErr.parser = p
+		}
+		userErrs = append(userErrs, userErr)
+		return nil
+	}
+
 	positionalArgIndex := 0
 	doubleDashSeen := false
+	// optionsEnded is like doubleDashSeen but caused by a positional argument
+	// in POSIX mode or a trailing positional argument, so a later -- is
+	// passed through as well.
+	optionsEnded := false
+
+	// Arguments like -5 can only be told apart from options if no short
+	// name is a digit.
+	negativeNumbers := !hasDigitShortName(programArgs)
 
 osArgsLoop:
-	for i := 1; i < len(osArgs); i++ {
+	for i := start + 1; i < len(osArgs); i++ {
 		arg := osArgs[i]
-		if arg == "--" {
+		if arg == "--" && !optionsEnded {
 			doubleDashSeen = true
 			continue
 		}
-		if !doubleDashSeen && strings.HasPrefix(arg, "--") {
-			long := arg[2:]
+		options := !doubleDashSeen && !optionsEnded
+		negativePositional := negativeNumbers &&
+			isNegativeNumber(arg) &&
+			positionalArgIndex < len(programPositionalArgs) &&
+			programPositionalArgs[positionalArgIndex].takesNegativeNumbers()
+		if options && !negativePositional && strings.HasPrefix(arg, "--") {
+			long, value, hasValue := strings.Cut(arg[2:], "=")
+			if p.abbrev {
+				expanded, err := expandLongName(programArgs, long, i-1)
+				if err != nil {
+					if err := report(err); err != nil {
+						return err
+					}
+					continue
+				}
+				long = expanded
+			}
 			if long == "help" {
-				printHelp(programArgs, os.Stdout)
-				os.Exit(0)
+				p.printHelp(programArgs, p.stdout)
+				return ErrHelp
+			}
+			if long == "help-all" && hasHelpAll {
+				p.printHelp(unhideArgs(programArgs), p.stdout)
+				return ErrHelp
 			}
 			arg, ok := getArgByLongName(programArgs, long)
+			negated := false
+			if !ok {
+				arg, ok = getNegatedArgByLongName(programArgs, long)
+				negated = ok
+			}
 			if !ok {
-				userErr("unknown argument: --"+long, programArgs)
+				if err := report(unknownArgumentErr("--"+long, i-1, programArgs)); err != nil {
+					return err
+				}
+				continue
+			}
+			givenNonPositionalArgs = append(givenNonPositionalArgs, givenArg{arg, "--" + long, i - 1})
+			p.warnDeprecated(arg, "--"+long)
+			var err error
+			if negated && hasValue {
+				err = userErr(InvalidValueError{
+					Field: arg.name,
+					Flag:  "--" + long,
+					Value: value,
+					Index: i - 1,
+					Err:   errors.New("argument does not take a value: --" + long),
+				}, nil)
+			} else if negated {
+				err = parseNonPositional(arg, strct, "false")
+			} else if hasValue {
+				err = parseNonPositionalWithValue(arg, "--"+long, strct, value, i-1)
 			} else {
-				givenNonPositionalArgs = append(givenNonPositionalArgs, arg)
+				i, err = parseNonPositionalAtIndex(osArgs, arg, "--"+long, strct, i, negativeNumbers)
 			}
-			i = parseNonPositionalAtIndex(osArgs, arg, strct, i)
-		} else if !doubleDashSeen && strings.HasPrefix(arg, "-") {
+			if err := report(err); err != nil {
+				return err
+			}
+		} else if options && !negativePositional && strings.HasPrefix(arg, "-") {
 			shortGrouped := arg[1:]
-			for _, rune := range shortGrouped {
+			for j, rune := range shortGrouped {
 				short := string(rune)
 				if short == "h" {
-					printHelp(programArgs, os.Stdout)
-					os.Exit(0)
+					p.printHelp(programArgs, p.stdout)
+					return ErrHelp
 				}
 				arg, ok := getArgByShortName(programArgs, short)
 				if !ok {
-					userErr("unknown argument: -"+short, programArgs)
+					if err := report(unknownArgumentErr("-"+short, i-1, programArgs)); err != nil {
+						return err
+					}
+					continue
+				}
+				givenNonPositionalArgs = append(givenNonPositionalArgs, givenArg{arg, "-" + short, i - 1})
+				p.warnDeprecated(arg, "-"+short)
+				// The first value-taking option in a group consumes the rest
+				// of the group as its value, or the next argument if it is
+				// the last one in the group.
+				rest := shortGrouped[j+len(short):]
+				var err error
+				if arg.takesValue() && rest != "" {
+					err = parseNonPositionalWithValue(arg, "-"+short, strct, rest, i-1)
 				} else {
-					givenNonPositionalArgs = append(givenNonPositionalArgs, arg)
+					i, err = parseNonPositionalAtIndex(osArgs, arg, "-"+short, strct, i, negativeNumbers)
+				}
+				if err := report(err); err != nil {
+					return err
+				}
+				if arg.takesValue() {
+					break
 				}
-				i = parseNonPositionalAtIndex(osArgs, arg, strct, i)
 			}
 		} else {
 			if len(programPositionalArgs) == 0 {
-				userErr("too many arguments", programArgs)
-			} else if positionalArgIndex >= len(programPositionalArgs) && programPositionalArgs[len(programPositionalArgs)-1].kind != reflect.Slice {
-				userErr("too many arguments", programArgs)
+				if err := report(userErr(TooManyArgumentsError{Value: arg, Index: i - 1}, programArgs)); err != nil {
+					return err
+				}
+			} else if positionalArgIndex >= len(programPositionalArgs) && !programPositionalArgs[len(programPositionalArgs)-1].isSlice() {
+				if err := report(userErr(TooManyArgumentsError{Value: arg, Index: i - 1}, programArgs)); err != nil {
+					return err
+				}
 			} else {
 				positionalArg := programPositionalArgs[positionalArgIndex]
 				givenPositionalArgs = append(givenPositionalArgs, positionalArg)
-				parsePositionalAtIndex(osArgs, positionalArg, strct, i)
+				if err := report(parsePositionalAtIndex(osArgs, positionalArg, strct, i)); err != nil {
+					return err
+				}
 				if positionalArg.cmd {
 					for _, arg := range programPositionalArgs {
 						if arg.cmdopt && osArgs[i] == toKebabCase(arg.name) {
-							prog = prog + " " + osArgs[i]
-							desc = ""
-							example = ""
+							sub := *p
+							sub.prog = p.prog + " " + osArgs[i]
+							sub.desc = ""
+							sub.example = ""
 							if arg.kind == reflect.Struct {
 								inst := reflect.New(arg.type_)
-								parse(osArgs[i:], inst.Interface())
+								if err := report(sub.parse(osArgs, i, inst.Interface())); err != nil {
+									return err
+								}
 								setStruct(strct, arg.name, inst.Elem().Interface())
 							} else if arg.kind == reflect.Interface {
-								parse(osArgs[i:], new(struct{}))
+								if err := report(sub.parse(osArgs, i, new(struct{}))); err != nil {
+									return err
+								}
 							}
 							break osArgsLoop
 						}
 					}
-					userErr("unknown cmd: "+osArgs[i], programArgs)
+					if err := report(unknownCommandErr(osArgs[i], i-1, programArgs)); err != nil {
+						return err
+					}
+					break osArgsLoop
 				} else if positionalArgIndex+1 < len(programPositionalArgs) {
 					positionalArgIndex++
 				}
+				// Options end with the first positional argument in POSIX
+				// mode, or once the positional arguments reach a trailing
+				// one, so that everything after is passed through.
+				if p.posixMode() || programPositionalArgs[positionalArgIndex].trailing {
+					optionsEnded = true
+				}
 			}
 		}
 	}
 
-	checkForConflicts(givenNonPositionalArgs)
-	checkForMissingMandatoryArgs(programArgs, givenNonPositionalArgs, givenPositionalArgs)
-	checkForMultipleUse(givenNonPositionalArgs)
+	userCheckErrs := make([]error, 0)
+	userCheckErrs = append(userCheckErrs, checkForConflicts(givenNonPositionalArgs)...)
+	userCheckErrs = append(userCheckErrs, checkForMissingMandatoryArgs(programArgs, givenNonPositionalArgs, givenPositionalArgs)...)
+	userCheckErrs = append(userCheckErrs, checkForMultipleUse(givenNonPositionalArgs)...)
+	for _, userCheckErr := range userCheckErrs {
+		if err := report(userCheckErr); err != nil {
+			return err
+		}
+	}
 
 programArgsLoop:
 	for _, arg := range programArgs {
@@ -308,114 +1063,777 @@ programArgsLoop:
 				continue programArgsLoop
 			}
 		}
-		if arg.positional {
-			parsePositional(arg, strct, arg.defaultValue)
+		if arg.isSlice() || arg.isMap() {
+			for _, value := range arg.defaultElems() {
+				var err error
+				if arg.isSlice() {
+					err = addSliceElem(arg, strct, value)
+				} else {
+					err = addMapEntry(arg, strct, value)
+				}
+				if err != nil {
+					if err := report(invalidValueErr(arg, arg.String(), value, -1, err)); err != nil {
+						return err
+					}
+				}
+			}
+		} else if arg.positional {
+			if err := parsePositional(arg, strct, arg.defaultValue); err != nil {
+				if err := report(invalidValueErr(arg, "", arg.defaultValue, -1, err)); err != nil {
+					return err
+				}
+			}
 		} else {
-			parseNonPositional(arg, strct, arg.defaultValue)
+			if err := parseNonPositional(arg, strct, arg.defaultValue); err != nil {
+				if err := report(invalidValueErr(arg, arg.String(), arg.defaultValue, -1, err)); err != nil {
+					return err
+				}
+			}
 		}
 	}
+
+	if len(userErrs) > 0 {
+		return joinUserErrs(userErrs, p)
+	}
+
+	return nil
 }
 
-func parseNonPositionalAtIndex(osArgs []string, arg arg, strct any, index int) int {
-	if arg.kind == reflect.Bool {
-		parseNonPositional(arg, strct, "")
-		return index
+func parseNonPositionalAtIndex(osArgs []string, arg arg, flag string, strct any, index int, negativeNumbers bool) (int, error) {
+	if arg.isBool() {
+		return index, parseNonPositional(arg, strct, "")
+	} else if isBoolValue(arg.type_) {
+		return index, parseNonPositionalWithValue(arg, flag, strct, "true", index-1)
+	} else if arg.count {
+		incrementInt(strct, arg.name)
+		return index, nil
+	} else if arg.optionalValue != "" {
+		// The value of an optional-value option can only be given as
+		// --long=value or -svalue, so the next argument is never consumed.
+		err := parseNonPositional(arg, strct, arg.optionalValue)
+		if err != nil {
+			return index, invalidValueErr(arg, flag, arg.optionalValue, -1, err)
+		}
+		return index, nil
 	} else {
 		if index+1 >= len(osArgs) {
-			userErr(fmt.Sprintf("missing value for: %s", arg), nil)
+			return index, userErr(MissingValueError{Field: arg.name, Flag: flag, Index: index - 1}, nil)
 		}
 		value := osArgs[index+1]
-		parseNonPositional(arg, strct, value)
-		return index + 1
+		// A value starting with a dash can only be given as --long=value
+		// to a long option, otherwise it would be ambiguous with an option.
+		// Negative numbers for numeric options are the exception.
+		negativeValue := negativeNumbers && isNegativeNumber(value) && arg.takesNegativeNumbers()
+		if strings.HasPrefix(flag, "--") && strings.HasPrefix(value, "-") && value != "-" && !negativeValue {
+			return index, userErr(MissingValueError{Field: arg.name, Flag: flag, Index: index - 1}, nil)
+		}
+		err := parseNonPositional(arg, strct, value)
+		if err != nil {
+			return index + 1, invalidValueErr(arg, flag, value, index, err)
+		}
+		return index + 1, nil
+	}
+}
+
+func parseNonPositionalWithValue(arg arg, flag string, strct any, value string, index int) error {
+	err := parseNonPositional(arg, strct, value)
+	if err != nil {
+		return invalidValueErr(arg, flag, value, index, err)
+	}
+	return nil
+}
+
+func parseNonPositional(arg arg, strct any, value string) error {
+	if !arg.isSlice() {
+		if err := checkChoice(arg, value); err != nil {
+			return err
+		}
+	}
+	if arg.type_ == reflect.TypeOf(time.Time{}) {
+		parsed, err := parseTime(value, arg.layout, arg.tz)
+		if err != nil {
+			return err
+		}
+		setValue(strct, arg.name, parsed)
+	} else if isValue(arg.type_) {
+		if err := setWithValue(strct, arg.name, value); err != nil {
+			return err
+		}
+	} else if isTextUnmarshaler(arg.type_) {
+		parsed, err := parseText(value, arg.type_)
+		if err != nil {
+			return err
+		}
+		setValue(strct, arg.name, parsed)
+	} else if arg.kind == reflect.Bool {
+		parsed, err := parseBool(value)
+		if err != nil {
+			return err
+		}
+		setBool(strct, arg.name, parsed)
+	} else if arg.kind == reflect.String {
+		setString(strct, arg.name, value)
+	} else if arg.type_ == reflect.TypeOf(time.Duration(0)) {
+		parsed, err := parseDuration(value)
+		if err != nil {
+			return err
+		}
+		setDuration(strct, arg.name, parsed)
+	} else if isInt(arg.kind) {
+		parsed, err := parseInt(value, arg.type_)
+		if err != nil {
+			return err
+		}
+		setInt(strct, arg.name, parsed)
+	} else if isUint(arg.kind) {
+		parsed, err := parseUint(value, arg.type_)
+		if err != nil {
+			return err
+		}
+		setUint(strct, arg.name, parsed)
+	} else if isFloat(arg.kind) {
+		parsed, err := parseFloat(value, arg.type_)
+		if err != nil {
+			return err
+		}
+		setFloat(strct, arg.name, parsed)
+	} else if arg.isSlice() {
+		if err := parseIntoSlice(arg, strct, value); err != nil {
+			return err
+		}
+	} else if arg.isMap() {
+		if err := parseIntoMap(arg, strct, value); err != nil {
+			return err
+		}
+	} else {
+		return developerErr(fmt.Sprintf("not implemented argument kind: %v", arg.kind))
 	}
+	return nil
+}
+
+func parsePositionalAtIndex(osArgs []string, arg arg, strct any, index int) error {
+	value := osArgs[index]
+	err := parsePositional(arg, strct, value)
+	if err != nil {
+		return invalidValueErr(arg, "", value, index-1, err)
+	}
+	return nil
+}
+
+func parsePositional(arg arg, strct any, value string) error {
+	if !arg.isSlice() {
+		if err := checkChoice(arg, value); err != nil {
+			return err
+		}
+	}
+	if arg.type_ == reflect.TypeOf(time.Time{}) {
+		parsed, err := parseTime(value, arg.layout, arg.tz)
+		if err != nil {
+			return err
+		}
+		setValue(strct, arg.name, parsed)
+	} else if isValue(arg.type_) {
+		if err := setWithValue(strct, arg.name, value); err != nil {
+			return err
+		}
+	} else if isTextUnmarshaler(arg.type_) {
+		parsed, err := parseText(value, arg.type_)
+		if err != nil {
+			return err
+		}
+		setValue(strct, arg.name, parsed)
+	} else if arg.kind == reflect.String {
+		setString(strct, arg.name, value)
+	} else if arg.type_ == reflect.TypeOf(time.Duration(0)) {
+		parsed, err := parseDuration(value)
+		if err != nil {
+			return err
+		}
+		setDuration(strct, arg.name, parsed)
+	} else if isInt(arg.kind) {
+		parsed, err := parseInt(value, arg.type_)
+		if err != nil {
+			return err
+		}
+		setInt(strct, arg.name, parsed)
+	} else if isUint(arg.kind) {
+		parsed, err := parseUint(value, arg.type_)
+		if err != nil {
+			return err
+		}
+		setUint(strct, arg.name, parsed)
+	} else if isFloat(arg.kind) {
+		parsed, err := parseFloat(value, arg.type_)
+		if err != nil {
+			return err
+		}
+		setFloat(strct, arg.name, parsed)
+	} else if arg.isSlice() {
+		if err := parseIntoSlice(arg, strct, value); err != nil {
+			return err
+		}
+	} else if arg.kind == reflect.Interface && arg.cmd {
+		setPointerTo(strct, arg.name, value)
+	} else {
+		return developerErr(fmt.Sprintf("not implemented argument kind: %v", arg.kind))
+	}
+	return nil
+}
+
+func parseIntoSlice(arg arg, strct any, value string) error {
+	values := []string{value}
+	if arg.sep != "" {
+		values = splitValue(value, arg.sep)
+	}
+	for _, value := range values {
+		if err := addSliceElem(arg, strct, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func addSliceElem(arg arg, strct any, value string) error {
+	if err := checkChoice(arg, value); err != nil {
+		return err
+	}
+	parsed, err := parseElem(arg, value)
+	if err != nil {
+		return err
+	}
+	addToSlice(strct, arg.name, parsed)
+	return nil
+}
+
+func parseIntoMap(arg arg, strct any, value string) error {
+	values := []string{value}
+	if arg.sep != "" {
+		values = splitValue(value, arg.sep)
+	}
+	for _, value := range values {
+		if err := addMapEntry(arg, strct, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func addMapEntry(arg arg, strct any, value string) error {
+	key, elem, ok := strings.Cut(value, "=")
+	if !ok {
+		return errors.New("value is not a key=value pair: " + value)
+	}
+	if arg.uniqueKeys && hasMapKey(strct, arg.name, key) {
+		return errors.New("duplicate key: " + key)
+	}
+	parsed, err := parseElem(arg, elem)
+	if err != nil {
+		return err
+	}
+	setMapEntry(strct, arg.name, key, parsed)
+	return nil
+}
+
+func checkChoice(arg arg, value string) error {
+	choices := arg.allChoices()
+	if len(choices) == 0 || slices.Contains(choices, value) {
+		return nil
+	}
+	t := arg.type_
+	if arg.isSlice() {
+		t = t.Elem()
+	}
+	if isInt(t.Kind()) || isUint(t.Kind()) {
+		// Integers are compared by value, so that e.g. 0x1 matches 1. A
+		// value that is not an integer is left to the parser to report.
+		parsed, err := parseChoiceInt(value, t)
+		if err != nil {
+			return nil
+		}
+		for _, choice := range choices {
+			if parsedChoice, err := parseChoiceInt(choice, t); err == nil && parsedChoice == parsed {
+				return nil
+			}
+		}
+	}
+	return InvalidChoiceError{Value: value, Choices: choices, Suggestion: suggest(value, choices)}
+}
+
+func parseChoiceInt(value string, t reflect.Type) (string, error) {
+	if isUint(t.Kind()) {
+		val, err := parseUint(value, t)
+		return strconv.FormatUint(val, 10), err
+	}
+	val, err := parseInt(value, t)
+	return strconv.FormatInt(val, 10), err
+}
+
+// sliceDefaultSep returns the separator of the elements in a slice default
+// value, which is sep if given or a comma otherwise.
+func sliceDefaultSep(sep string) string {
+	if sep != "" {
+		return sep
+	}
+	return ","
+}
+
+// splitValue splits value at each sep that is not escaped with a backslash.
+func splitValue(value string, sep string) []string {
+	values := make([]string, 0)
+	var sb strings.Builder
+	for i := 0; i < len(value); {
+		if strings.HasPrefix(value[i:], "\\"+sep) {
+			sb.WriteString(sep)
+			i += 1 + len(sep)
+		} else if strings.HasPrefix(value[i:], sep) {
+			values = append(values, sb.String())
+			sb.Reset()
+			i += len(sep)
+		} else {
+			sb.WriteByte(value[i])
+			i++
+		}
+	}
+	return append(values, sb.String())
+}
+
+// parseElem parses a slice element or a map value.
+func parseElem(arg arg, value string) (any, error) {
+	innerType := arg.type_.Elem()
+	innerKind := innerType.Kind()
+	if innerType == reflect.TypeOf(time.Duration(0)) {
+		val, err := parseDuration(value)
+		return val, err
+	} else if innerType == reflect.TypeOf(time.Time{}) {
+		val, err := parseTime(value, arg.layout, arg.tz)
+		return val, err
+	} else if isValue(innerType) {
+		val := reflect.New(innerType)
+		if err := val.Interface().(Value).Set(value); err != nil {
+			return nil, valueErr(value, innerType, err)
+		}
+		return val.Elem().Interface(), nil
+	} else if isTextUnmarshaler(innerType) {
+		return parseText(value, innerType)
+	} else if innerKind == reflect.String {
+		return value, nil
+	} else if innerKind == reflect.Bool {
+		val, err := parseBool(value)
+		return val, err
+	} else if isInt(innerKind) {
+		val, err := parseInt(value, innerType)
+		return val, err
+	} else if isUint(innerKind) {
+		val, err := parseUint(value, innerType)
+		return val, err
+	} else if isFloat(innerKind) {
+		val, err := parseFloat(value, innerType)
+		return val, err
+	} else {
+		return nil, developerErr("not implemented argument kind " + arg.type_.String())
+	}
+}
+
+// parseBool returns true for an empty value, which is the case for a bool
+// option given without a value.
+func parseBool(arg string) (bool, error) {
+	if arg == "" {
+		return true, nil
+	}
+	val, err := strconv.ParseBool(arg)
+	if err != nil {
+		return false, errors.New("value is not a bool: " + arg)
+	}
+	return val, nil
+}
+
+// parseInt parses a signed integer that fits into t. The integer may have a
+// 0x, 0o or 0b prefix and contain underscores, as in Go source code.
+func parseInt(arg string, t reflect.Type) (int64, error) {
+	val, err := strconv.ParseInt(intLiteral(arg), 0, t.Bits())
+	if errors.Is(err, strconv.ErrRange) {
+		return 0, outOfRangeErr(arg, t)
+	} else if err != nil {
+		return 0, errors.New("value is not an int: " + arg)
+	}
+	return val, nil
+}
+
+func parseUint(arg string, t reflect.Type) (uint64, error) {
+	val, err := strconv.ParseUint(intLiteral(arg), 0, t.Bits())
+	if errors.Is(err, strconv.ErrRange) {
+		return 0, outOfRangeErr(arg, t)
+	} else if err != nil {
+		if _, err := strconv.ParseInt(intLiteral(arg), 0, 64); err == nil {
+			return 0, outOfRangeErr(arg, t)
+		}
+		return 0, errors.New("value is not an unsigned int: " + arg)
+	}
+	return val, nil
+}
+
+// intLiteral strips leading zeros from a decimal integer, so that it is not
+// taken as an octal number by strconv.
+func intLiteral(arg string) string {
+	sign, digits := "", arg
+	if strings.HasPrefix(arg, "-") || strings.HasPrefix(arg, "+") {
+		sign, digits = arg[:1], arg[1:]
+	}
+	if len(digits) > 1 && digits[0] == '0' && unicode.IsDigit(rune(digits[1])) {
+		digits = strings.TrimLeft(digits, "0")
+		if digits == "" {
+			digits = "0"
+		}
+	}
+	return sign + digits
+}
+
+func parseFloat(arg string, t reflect.Type) (float64, error) {
+	val, err := strconv.ParseFloat(arg, t.Bits())
+	if errors.Is(err, strconv.ErrRange) {
+		return 0, outOfRangeErr(arg, t)
+	} else if err != nil {
+		return 0, errors.New("value is not a float: " + arg)
+	}
+	return val, nil
+}
+
+var (
+	valueType           = reflect.TypeFor[Value]()
+	textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+	textMarshalerType   = reflect.TypeFor[encoding.TextMarshaler]()
+)
+
+func isValue(t reflect.Type) bool {
+	return reflect.PointerTo(t).Implements(valueType)
+}
+
+// isBoolValue reports whether t is a Value that takes no argument, like a
+// flag.Value with an IsBool method returning true.
+func isBoolValue(t reflect.Type) bool {
+	if !isValue(t) {
+		return false
+	}
+	boolValue, ok := reflect.New(t).Interface().(interface{ IsBool() bool })
+	return ok && boolValue.IsBool()
+}
+
+func valueErr(arg string, t reflect.Type, err error) error {
+	return fmt.Errorf("value is not a valid %s: %s: %w", t, arg, err)
+}
+
+func isTextUnmarshaler(t reflect.Type) bool {
+	return reflect.PointerTo(t).Implements(textUnmarshalerType)
+}
+
+// parseText parses arg into a new value of type t using its UnmarshalText
+// method.
+func parseText(arg string, t reflect.Type) (any, error) {
+	val := reflect.New(t)
+	if err := val.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(arg)); err != nil {
+		return nil, valueErr(arg, t, err)
+	}
+	return val.Elem().Interface(), nil
+}
+
+// formatText returns the canonical form of arg if t is a Value or can be both
+// unmarshaled from and marshaled to text, e.g. "WARN" for a slog.Level given
+// as "warn". Otherwise arg is returned as is.
+func formatText(t reflect.Type, arg string) string {
+	if arg != "" && isValue(t) {
+		val := reflect.New(t).Interface().(Value)
+		if err := val.Set(arg); err != nil {
+			return arg
+		}
+		return val.String()
+	}
+	if arg == "" || !isTextUnmarshaler(t) || !reflect.PointerTo(t).Implements(textMarshalerType) {
+		return arg
+	}
+	val := reflect.New(t)
+	if err := val.Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(arg)); err != nil {
+		return arg
+	}
+	text, err := val.Interface().(encoding.TextMarshaler).MarshalText()
+	if err != nil {
+		return arg
+	}
+	return string(text)
+}
+
+func outOfRangeErr(arg string, t reflect.Type) error {
+	return fmt.Errorf("value %s out of range for %s", arg, t.Kind())
+}
+
+func parseDuration(arg string) (time.Duration, error) {
+	val, err := time.ParseDuration(arg)
+	if err != nil {
+		return 0, errors.New("value is not a duration: " + arg)
+	}
+	return val, nil
+}
+
+// timeLayouts are the names that can be given to the layout tag instead of a
+// layout. The value of the unix layout is the number of seconds since the
+// Unix epoch.
+var timeLayouts = map[string]string{
+	"date":     time.DateOnly,
+	"datetime": time.DateTime,
+	"rfc3339":  time.RFC3339,
+	"unix":     "unix",
+}
+
+// parseTime parses arg as a time in the given layout, which is RFC3339 if
+// empty. Besides a time in the layout, arg can be "now", "today" or a
+// duration relative to now like -2h. Times without a time zone, as well as
+// today, are in the time zone tz, which is the local time zone if empty.
+func parseTime(arg string, layout string, tz string) (time.Time, error) {
+	loc, err := loadLocation(tz)
+	if err != nil {
+		return time.Time{}, developerErr("invalid tz: " + tz)
+	}
+	if layout == "" {
+		layout = time.RFC3339
+	} else if named, ok := timeLayouts[layout]; ok {
+		layout = named
+	}
+	if arg == "now" {
+		return time.Now().In(loc), nil
+	} else if arg == "today" {
+		year, month, day := time.Now().In(loc).Date()
+		return time.Date(year, month, day, 0, 0, 0, 0, loc), nil
+	} else if strings.HasPrefix(arg, "-") || strings.HasPrefix(arg, "+") {
+		if d, err := time.ParseDuration(arg); err == nil {
+			return time.Now().Add(d).In(loc), nil
+		}
+	}
+	if layout == "unix" {
+		sec, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			return time.Time{}, errors.New("value is not a time: " + arg + ", expected seconds since the Unix epoch")
+		}
+		return time.Unix(sec, 0).In(loc), nil
+	}
+	val, err := time.ParseInLocation(layout, arg, loc)
+	if err != nil {
+		return time.Time{}, errors.New("value is not a time: " + arg + ", expected layout " + layout)
+	}
+	return val, nil
+}
+
+func loadLocation(tz string) (*time.Location, error) {
+	if tz == "" || tz == "Local" {
+		return time.Local, nil
+	}
+	return time.LoadLocation(tz)
+}
+
+// isNegativeNumber reports whether s looks like a negative number, e.g. -5 or
+// -.25. Whether it actually is a valid number is up to the parser of the
+// field.
+func isNegativeNumber(s string) bool {
+	if len(s) < 2 || s[0] != '-' {
+		return false
+	}
+	if s[1] == '.' && len(s) > 2 {
+		return unicode.IsDigit(rune(s[2]))
+	}
+	return unicode.IsDigit(rune(s[1]))
+}
+
+// takesNegativeNumbers reports whether a value of arg can start with a dash,
+// i.e. whether it is numeric or a time that can be relative to now, like -2h.
+func (arg arg) takesNegativeNumbers() bool {
+	t := arg.type_
+	if arg.isSlice() {
+		t = t.Elem()
+	}
+	if t == reflect.TypeOf(time.Time{}) {
+		return true
+	}
+	return !isValue(t) && !isTextUnmarshaler(t) && isNumeric(t)
+}
+
+func isNumeric(t reflect.Type) bool {
+	return isInt(t.Kind()) || isUint(t.Kind()) || isFloat(t.Kind())
+}
+
+func isInt(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return true
+	default:
+		return false
+	}
+}
+
+func isUint(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return true
+	default:
+		return false
+	}
+}
+
+func isFloat(kind reflect.Kind) bool {
+	return kind == reflect.Float32 || kind == reflect.Float64
 }
 
-func parseNonPositional(arg arg, strct any, value string) {
-	if arg.kind == reflect.Bool {
-		setBool(strct, arg.name, true)
-	} else if arg.kind == reflect.String {
-		setString(strct, arg.name, value)
-	} else if arg.kind == reflect.Int {
-		setInt(strct, arg.name, parseInt(value))
-	} else if arg.kind == reflect.Float64 {
-		setFloat(strct, arg.name, parseFloat(value))
-	} else if arg.kind == reflect.Slice {
-		innerKind := arg.type_.Elem().Kind()
-		var parsed any
-		if innerKind == reflect.String {
-			parsed = value
-		} else if innerKind == reflect.Int {
-			parsed = parseInt(value)
-		} else if innerKind == reflect.Float64 {
-			parsed = parseFloat(value)
-		} else {
-			developerErr("not implemented argument kind []" + innerKind.String())
+func hasDigitShortName(args []arg) bool {
+	for _, arg := range args {
+		for _, short := range arg.shortNames(true) {
+			if unicode.IsDigit([]rune(short)[0]) {
+				return true
+			}
 		}
-		addToSlice(strct, arg.name, parsed)
-	} else if arg.type_ == reflect.TypeOf(time.Duration(0)) {
-		setDuration(strct, arg.name, parseDuration(value))
-	} else {
-		developerErr(fmt.Sprintf("not implemented argument kind: %v", arg.kind))
-		panic("unreachable")
 	}
+	return false
 }
 
-func parsePositionalAtIndex(osArgs []string, arg arg, strct any, index int) {
-	value := osArgs[index]
-	parsePositional(arg, strct, value)
+const maxResponseFileDepth = 10
+
+type responseFileToken struct {
+	value string
+	line  int
 }
 
-func parsePositional(arg arg, strct any, value string) {
-	if arg.kind == reflect.String {
-		setString(strct, arg.name, value)
-	} else if arg.kind == reflect.Int {
-		setInt(strct, arg.name, parseInt(value))
-	} else if arg.kind == reflect.Float64 {
-		setFloat(strct, arg.name, parseFloat(value))
-	} else if arg.kind == reflect.Slice {
-		innerKind := arg.type_.Elem().Kind()
-		var parsed any
-		if innerKind == reflect.String {
-			parsed = value
-		} else if innerKind == reflect.Int {
-			parsed = parseInt(value)
-		} else if innerKind == reflect.Float64 {
-			parsed = parseFloat(value)
-		} else {
-			developerErr("not implemented argument kind []" + innerKind.String())
+// expandResponseFiles expands the response files in args. Arguments after --
+// are left untouched.
+func expandResponseFiles(args []string) ([]string, error) {
+	expanded := make([]string, 0, len(args))
+	for i, arg := range args {
+		if arg == "--" {
+			return append(expanded, args[i:]...), nil
 		}
-		addToSlice(strct, arg.name, parsed)
-	} else if arg.kind == reflect.Interface && arg.cmd {
-		setPointerTo(strct, arg.name, value)
-	} else if arg.type_ == reflect.TypeOf(time.Duration(0)) {
-		setDuration(strct, arg.name, parseDuration(value))
-	} else {
-		developerErr(fmt.Sprintf("not implemented argument kind: %v", arg.kind))
+		argExpanded, err := expandResponseFileArg(arg, "", 0, 0)
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, argExpanded...)
 	}
+	return expanded, nil
 }
 
-func parseInt(arg string) int {
-	val, err := strconv.Atoi(arg)
+// expandResponseFileArg returns the arguments arg expands to. file and line
+// are where arg was read from, if it was read from a response file.
+func expandResponseFileArg(arg string, file string, line int, depth int) ([]string, error) {
+	if strings.HasPrefix(arg, "@@") {
+		return []string{arg[1:]}, nil
+	}
+	if !strings.HasPrefix(arg, "@") || arg == "@" {
+		return []string{arg}, nil
+	}
+	path := arg[1:]
+	if depth >= maxResponseFileDepth {
+		return nil, userErr(ResponseFileError{File: file, Line: line, Err: errors.New("response files nested too deeply: " + path)}, nil)
+	}
+	content, err := os.ReadFile(path)
 	if err != nil {
-		userErr("value is not an int: "+arg, nil)
+		var pathErr *fs.PathError
+		if errors.As(err, &pathErr) {
+			err = pathErr.Err
+		}
+		if file != "" {
+			return nil, userErr(ResponseFileError{File: file, Line: line, Err: fmt.Errorf("%s: %w", path, err)}, nil)
+		}
+		return nil, userErr(ResponseFileError{File: path, Err: err}, nil)
 	}
-	return val
-}
-
-func parseFloat(arg string) float64 {
-	val, err := strconv.ParseFloat(arg, 64)
+	tokens, err := splitResponseFile(path, string(content))
 	if err != nil {
-		userErr("value is not a float: "+arg, nil)
+		return nil, err
 	}
-	return val
+	expanded := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		tokenExpanded, err := expandResponseFileArg(token.value, path, token.line, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		expanded = append(expanded, tokenExpanded...)
+	}
+	return expanded, nil
 }
 
-func parseDuration(arg string) time.Duration {
-	val, err := time.ParseDuration(arg)
-	if err != nil {
-		userErr("value is not a duration: "+arg, nil)
+// splitResponseFile splits content into arguments like a shell would. Single
+// quotes preserve everything, within double quotes a backslash escapes a
+// double quote or a backslash, and outside of quotes a backslash escapes any
+// character. A # at the start of an argument comments out the rest of the
+// line.
+func splitResponseFile(path string, content string) ([]responseFileToken, error) {
+	lineAt := func(i int) int {
+		return strings.Count(content[:i], "\n") + 1
+	}
+
+	tokens := make([]responseFileToken, 0)
+
+	var sb strings.Builder
+	inToken := false
+	tokenLine := 0
+	var quote byte
+	quoteStart := 0
+
+	startToken := func(i int) {
+		if !inToken {
+			inToken = true
+			tokenLine = lineAt(i)
+		}
+	}
+
+	for i := 0; i < len(content); i++ {
+		ch := content[i]
+		switch {
+		case quote == '\'':
+			if ch == '\'' {
+				quote = 0
+			} else {
+				sb.WriteByte(ch)
+			}
+		case quote == '"':
+			if ch == '"' {
+				quote = 0
+			} else if ch == '\\' && i+1 < len(content) && (content[i+1] == '"' || content[i+1] == '\\') {
+				i++
+				sb.WriteByte(content[i])
+			} else {
+				sb.WriteByte(ch)
+			}
+		case ch == '\'' || ch == '"':
+			startToken(i)
+			quote = ch
+			quoteStart = i
+		case ch == '\\':
+			if i+1 < len(content) {
+				i++
+				if content[i] == '\n' {
+					continue
+				}
+				startToken(i - 1)
+				sb.WriteByte(content[i])
+			}
+		case ch == '#' && !inToken:
+			for i+1 < len(content) && content[i+1] != '\n' {
+				i++
+			}
+		case unicode.IsSpace(rune(ch)):
+			if inToken {
+				tokens = append(tokens, responseFileToken{sb.String(), tokenLine})
+				sb.Reset()
+				inToken = false
+			}
+		default:
+			startToken(i)
+			sb.WriteByte(ch)
+		}
+	}
+
+	if quote != 0 {
+		return nil, userErr(ResponseFileError{File: path, Line: lineAt(quoteStart), Err: errors.New("unterminated quote")}, nil)
+	}
+	if inToken {
+		tokens = append(tokens, responseFileToken{sb.String(), tokenLine})
 	}
-	return val
+
+	return tokens, nil
 }
 
 func isStructPointer(strct any) bool {
@@ -425,144 +1843,247 @@ func isStructPointer(strct any) bool {
 
 func getArgByLongName(args []arg, name string) (arg, bool) {
 	for _, arg := range args {
-		if arg.long == name {
+		if slices.Contains(arg.longNames(true), name) {
+			return arg, true
+		}
+	}
+	return arg{}, false
+}
+
+func getNegatedArgByLongName(args []arg, name string) (arg, bool) {
+	for _, arg := range args {
+		if slices.Contains(arg.negatedLongNames(true), name) {
 			return arg, true
 		}
 	}
 	return arg{}, false
 }
 
+// expandLongName returns the long name that name is a unique prefix of. An
+// exact match always wins. If there is no match, name is returned unchanged.
+func expandLongName(args []arg, name string, index int) (string, error) {
+	for _, arg := range args {
+		if !arg.positional && slices.Contains(append(arg.longNames(true), arg.negatedLongNames(true)...), name) {
+			return name, nil
+		}
+	}
+	// Hidden options and hidden aliases, as well as --help-all, are only
+	// accepted when given in full, so that they neither show up as
+	// candidates nor make the prefix of a visible option ambiguous.
+	// Several names of the same option, like an option and its aliases,
+	// count as a single match. The negated names of an option are a
+	// different match though, as they have a different meaning.
+	matches := make([]string, 0)
+	for _, arg := range args {
+		if arg.positional || arg.hidden || arg.name == "HelpAll" {
+			continue
+		}
+		for _, longs := range [][]string{arg.longNames(false), arg.negatedLongNames(false)} {
+			for _, long := range longs {
+				if strings.HasPrefix(long, name) {
+					matches = append(matches, long)
+					break
+				}
+			}
+		}
+	}
+	if len(matches) == 0 {
+		return name, nil
+	}
+	if len(matches) > 1 {
+		candidates := make([]string, 0)
+		for _, match := range matches {
+			candidates = append(candidates, "--"+match)
+		}
+		return "", userErr(AmbiguousArgumentError{Flag: "--" + name, Index: index, Candidates: candidates}, nil)
+	}
+	return matches[0], nil
+}
+
 func getArgByShortName(args []arg, name string) (arg, bool) {
 	for _, arg := range args {
-		if arg.short == name {
+		if slices.Contains(arg.shortNames(true), name) {
 			return arg, true
 		}
 	}
 	return arg{}, false
 }
 
-func setInt(strct any, name string, val int) {
-	reflect.ValueOf(strct).Elem().FieldByName(name).SetInt(int64(val))
+// fieldByName returns the field of strct with the given name. If the field is
+// a pointer, it is allocated if nil and the value it points to is returned.
+func fieldByName(strct any, name string) reflect.Value {
+	field := reflect.ValueOf(strct).Elem().FieldByName(name)
+	if field.Kind() == reflect.Pointer {
+		if field.IsNil() {
+			field.Set(reflect.New(field.Type().Elem()))
+		}
+		return field.Elem()
+	}
+	return field
+}
+
+func setInt(strct any, name string, val int64) {
+	fieldByName(strct, name).SetInt(val)
+}
+
+func setUint(strct any, name string, val uint64) {
+	fieldByName(strct, name).SetUint(val)
+}
+
+func incrementInt(strct any, name string) {
+	field := fieldByName(strct, name)
+	field.SetInt(field.Int() + 1)
+}
+
+func hasMapKey(strct any, name string, key string) bool {
+	field := fieldByName(strct, name)
+	return !field.IsNil() && field.MapIndex(reflect.ValueOf(key).Convert(field.Type().Key())).IsValid()
+}
+
+func setMapEntry(strct any, name string, key string, val any) {
+	field := fieldByName(strct, name)
+	if field.IsNil() {
+		field.Set(reflect.MakeMap(field.Type()))
+	}
+	field.SetMapIndex(reflect.ValueOf(key).Convert(field.Type().Key()), reflect.ValueOf(val).Convert(field.Type().Elem()))
 }
 
 func setFloat(strct any, name string, val float64) {
-	reflect.ValueOf(strct).Elem().FieldByName(name).SetFloat(val)
+	fieldByName(strct, name).SetFloat(val)
 }
 
 func setBool(strct any, name string, val bool) {
-	reflect.ValueOf(strct).Elem().FieldByName(name).SetBool(val)
+	fieldByName(strct, name).SetBool(val)
 }
 
 func setString(strct any, name string, val string) {
-	reflect.ValueOf(strct).Elem().FieldByName(name).SetString(val)
+	fieldByName(strct, name).SetString(val)
 }
 
 func setDuration(strct any, name string, val time.Duration) {
-	reflect.ValueOf(strct).Elem().FieldByName(name).Set(reflect.ValueOf(val))
+	fieldByName(strct, name).Set(reflect.ValueOf(val))
 }
 
 func setPointerTo(strct any, name string, val string) {
 	cmd := reflect.ValueOf(strct).Elem().FieldByName(kebabToPascalCase(val))
 	if cmd.IsValid() {
-		reflect.ValueOf(strct).Elem().FieldByName(name).Set(cmd.Addr())
+		fieldByName(strct, name).Set(cmd.Addr())
+	}
+}
+
+// setWithValue calls Set of the Value field. A nil pointer field is only
+// assigned once Set succeeded, so that it stays nil on error.
+func setWithValue(strct any, name string, val string) error {
+	field := reflect.ValueOf(strct).Elem().FieldByName(name)
+	if field.Kind() == reflect.Pointer && field.IsNil() {
+		ptr := reflect.New(field.Type().Elem())
+		if err := ptr.Interface().(Value).Set(val); err != nil {
+			return valueErr(val, ptr.Elem().Type(), err)
+		}
+		field.Set(ptr)
+		return nil
+	}
+	field = fieldByName(strct, name)
+	if err := field.Addr().Interface().(Value).Set(val); err != nil {
+		return valueErr(val, field.Type(), err)
 	}
+	return nil
+}
+
+func setValue(strct any, name string, val any) {
+	fieldByName(strct, name).Set(reflect.ValueOf(val))
 }
 
 func setStruct(strct any, name string, val any) {
-	reflect.ValueOf(strct).Elem().FieldByName(name).Set(reflect.ValueOf(val))
+	fieldByName(strct, name).Set(reflect.ValueOf(val))
 }
 
 func addToSlice(strct any, name string, val any) {
-	field := reflect.ValueOf(strct).Elem().FieldByName(name)
+	field := fieldByName(strct, name)
 	if field.IsNil() {
 		field.Set(reflect.MakeSlice(field.Type(), 0, 1))
 	}
-	updatedSlice := reflect.Append(field, reflect.ValueOf(val))
+	updatedSlice := reflect.Append(field, reflect.ValueOf(val).Convert(field.Type().Elem()))
 	field.Set(updatedSlice)
 }
 
-func checkForNameCollisions(args []arg) {
+func checkForNameCollisions(args []arg) error {
 	seenLong := make(map[string]arg)
 	seenShort := make(map[string]arg)
 	for _, arg := range args {
 		if arg.positional {
 			continue
 		}
-		if arg.long != "" {
-			existing, exists := seenLong[arg.long]
+		for _, long := range append(arg.longNames(true), arg.negatedLongNames(true)...) {
+			existing, exists := seenLong[long]
 			if !exists {
-				seenLong[arg.long] = arg
+				seenLong[long] = arg
 			} else {
-				developerErr(fmt.Sprintf("argument name collision: %s (--%s) with %s (--%s)", arg.name, arg.long, existing.name, existing.long))
+				return developerErr(fmt.Sprintf("argument name collision: %s (--%s) with %s (--%s)", arg.name, long, existing.name, long))
 			}
 		}
-		if arg.short != "" {
-			existing, exists := seenShort[arg.short]
+		for _, short := range arg.shortNames(true) {
+			existing, exists := seenShort[short]
 			if !exists {
-				seenShort[arg.short] = arg
+				seenShort[short] = arg
 			} else {
-				developerErr(fmt.Sprintf("argument name collision: %s (-%s) with %s (-%s)", arg.name, arg.short, existing.name, existing.short))
+				return developerErr(fmt.Sprintf("argument name collision: %s (-%s) with %s (-%s)", arg.name, short, existing.name, short))
 			}
 		}
 	}
+	return nil
 }
 
-func checkForSlicesWithDefaultValue(programArgs []arg) {
-	for _, arg := range programArgs {
-		if arg.kind == reflect.Slice && arg.defaultValue != "" {
-			developerErr("slice arguments cannot have default values: " + arg.name)
-		}
-	}
-}
-
-func checkForMandatoryArgsWithDefaultValue(programArgs []arg) {
+func checkForMandatoryArgsWithDefaultValue(programArgs []arg) error {
 	for _, arg := range programArgs {
 		if arg.mandatory && arg.defaultValue != "" {
-			developerErr("mandatory arguments cannot have default values: " + arg.name)
+			return developerErr("mandatory arguments cannot have default values: " + arg.name)
 		}
 	}
+	return nil
 }
 
-func checkForEitherLongOrShortGiven(programNonPositionalArgs []arg) {
+func checkForEitherLongOrShortGiven(programNonPositionalArgs []arg) error {
 	for _, arg := range programNonPositionalArgs {
 		if arg.long == "" && arg.short == "" {
-			developerErr("Either long or short name must be specified: " + arg.name)
+			return developerErr("Either long or short name must be specified: " + arg.name)
 		}
 	}
+	return nil
 }
 
-func checkForInvalidPositionalArguments(programPositionalArgs []arg) {
+func checkForInvalidPositionalArguments(programPositionalArgs []arg) error {
 	sliceSeen := false
 	optionalSeen := false
 	cmdSeen := false
 
 	for _, arg := range programPositionalArgs {
-		if arg.kind != reflect.Slice && sliceSeen {
-			developerErr("positional arguments of slices can only be located at the end: " + arg.name)
+		if !arg.isSlice() && sliceSeen {
+			return developerErr("positional arguments of slices can only be located at the end: " + arg.name)
 		}
-		if arg.kind == reflect.Slice && optionalSeen {
-			developerErr("when slice as a positional argument is used, all preceding positional arguments must be mandatory: " + arg.name)
+		if arg.isSlice() && optionalSeen {
+			return developerErr("when slice as a positional argument is used, all preceding positional arguments must be mandatory: " + arg.name)
 		}
 		if arg.mandatory && optionalSeen {
-			developerErr("you cannot have mandatory positional arguments after optional ones: " + arg.name)
+			return developerErr("you cannot have mandatory positional arguments after optional ones: " + arg.name)
 		}
 		if arg.cmd && arg.kind != reflect.Interface {
-			developerErr("cmd must be of type any: " + arg.name)
+			return developerErr("cmd must be of type any: " + arg.name)
 		}
 		if arg.cmd && cmdSeen {
-			developerErr("you cannot have multiple cmds on the same level: " + arg.name)
+			return developerErr("you cannot have multiple cmds on the same level: " + arg.name)
 		}
 		if arg.cmd && arg.cmdopt {
-			developerErr("you cannot declare a field as a cmd and a cmdopt: " + arg.name)
+			return developerErr("you cannot declare a field as a cmd and a cmdopt: " + arg.name)
 		}
 		if arg.cmdopt && arg.kind != reflect.Interface && arg.kind != reflect.Struct {
-			developerErr("cmdopt must be of type any or struct: " + arg.name)
+			return developerErr("cmdopt must be of type any or struct: " + arg.name)
 		}
 		if arg.cmdopt && arg.kind == reflect.Struct && arg.type_.NumField() == 0 {
-			developerErr("empty struct not allowed for cmdopt, use any type: " + arg.name)
+			return developerErr("empty struct not allowed for cmdopt, use any type: " + arg.name)
 		}
 		// TODO cmd must be any, cmdopt any or struct, but not empty struct!
-		if arg.kind == reflect.Slice {
+		if arg.isSlice() {
 			sliceSeen = true
 		}
 		if !arg.mandatory {
@@ -572,23 +2093,179 @@ func checkForInvalidPositionalArguments(programPositionalArgs []arg) {
 			cmdSeen = true
 		}
 	}
+	return nil
+}
+
+func checkForInvalidNegatableArgs(programArgs []arg) error {
+	for _, arg := range programArgs {
+		if arg.negatable && !arg.isBool() {
+			return developerErr("negatable must be of type bool: " + arg.name)
+		}
+		if arg.negatable && arg.long == "" {
+			return developerErr("negatable arguments must have a long name: " + arg.name)
+		}
+	}
+	return nil
+}
+
+func checkForInvalidOptionalValueArgs(programArgs []arg) error {
+	for _, arg := range programArgs {
+		if arg.optionalValue != "" && arg.isBool() {
+			return developerErr("bool arguments cannot have an optional value: " + arg.name)
+		}
+		if arg.optionalValue != "" && arg.positional {
+			return developerErr("positional arguments cannot have an optional value: " + arg.name)
+		}
+	}
+	return nil
+}
+
+func checkForInvalidCountArgs(programArgs []arg) error {
+	for _, arg := range programArgs {
+		if arg.count && !isInt(arg.kind) {
+			return developerErr("count must be of a signed integer type: " + arg.name)
+		}
+		if arg.count && arg.positional {
+			return developerErr("positional arguments cannot be counted: " + arg.name)
+		}
+	}
+	return nil
+}
+
+func checkForInvalidSepArgs(programArgs []arg) error {
+	for _, arg := range programArgs {
+		if arg.sep != "" && !arg.isSlice() && !arg.isMap() {
+			return developerErr("sep can only be used with slices and maps: " + arg.name)
+		}
+	}
+	return nil
+}
+
+func checkForInvalidAliases(programArgs []arg) error {
+	for _, arg := range programArgs {
+		if len(arg.aliases) > 0 && arg.positional {
+			return developerErr("positional arguments cannot have aliases: " + arg.name)
+		}
+		for _, alias := range arg.aliases {
+			if alias.name == "" {
+				return developerErr("alias must not be empty: " + arg.name)
+			}
+			if alias.short && len([]rune(alias.name)) != 1 {
+				return developerErr("short alias must be a single character: " + arg.name)
+			}
+		}
+	}
+	return nil
+}
+
+func checkForInvalidDeprecatedArgs(programArgs []arg) error {
+	for _, arg := range programArgs {
+		if arg.deprecated != "" && arg.positional {
+			return developerErr("positional arguments cannot be deprecated: " + arg.name)
+		}
+	}
+	return nil
+}
+
+func checkForInvalidTrailingArgs(programArgs []arg) error {
+	for _, arg := range programArgs {
+		if arg.trailing && (!arg.positional || !arg.isSlice() || arg.cmd || arg.cmdopt) {
+			return developerErr("trailing must be a positional slice: " + arg.name)
+		}
+	}
+	return nil
+}
+
+func checkForInvalidPointerArgs(programArgs []arg) error {
+	for _, arg := range programArgs {
+		if !arg.pointer {
+			continue
+		}
+		if arg.cmd || arg.cmdopt {
+			return developerErr("cmd and cmdopt arguments cannot be pointers: " + arg.name)
+		}
+		if arg.isSlice() || arg.isMap() || arg.kind == reflect.Pointer {
+			return developerErr("pointer arguments cannot point to slices, maps or pointers: " + arg.name)
+		}
+	}
+	return nil
+}
+
+func checkForInvalidChoicesArgs(programArgs []arg) error {
+	for _, arg := range programArgs {
+		if len(arg.choices) == 0 {
+			continue
+		}
+		kind := arg.kind
+		if arg.isSlice() {
+			kind = arg.type_.Elem().Kind()
+		}
+		if kind != reflect.String && !isInt(kind) && !isUint(kind) {
+			return developerErr("choices can only be used with strings and integers: " + arg.name)
+		}
+	}
+	return nil
+}
+
+func checkForInvalidTimeArgs(programArgs []arg) error {
+	for _, arg := range programArgs {
+		t := arg.type_
+		if arg.isSlice() || arg.isMap() {
+			t = t.Elem()
+		}
+		isTime := t == reflect.TypeOf(time.Time{})
+		if (arg.layout != "" || arg.tz != "") && !isTime {
+			return developerErr("layout and tz can only be used with time.Time: " + arg.name)
+		}
+		if _, err := loadLocation(arg.tz); err != nil {
+			return developerErr("invalid tz: " + arg.name)
+		}
+	}
+	return nil
+}
+
+func checkForInvalidMapArgs(programArgs []arg) error {
+	for _, arg := range programArgs {
+		if arg.isMap() && arg.positional {
+			return developerErr("positional arguments cannot be maps: " + arg.name)
+		}
+		if arg.isMap() && arg.type_.Key().Kind() != reflect.String {
+			return developerErr("map keys must be of type string: " + arg.name)
+		}
+		if arg.uniqueKeys && !arg.isMap() {
+			return developerErr("unique_keys can only be used with maps: " + arg.name)
+		}
+	}
+	return nil
 }
 
-func checkForConflicts(givenNonPositionalArgs []arg) {
+func checkForConflicts(givenNonPositionalArgs []givenArg) []error {
+	errs := make([]error, 0)
 	for _, outerArg := range givenNonPositionalArgs {
 		for _, inConflict := range outerArg.conflictsWith {
 			for _, innerArg := range givenNonPositionalArgs {
 				if innerArg.name == inConflict {
-					userErr(fmt.Sprintf("conflicting arguments: %s, %s", outerArg, innerArg), nil)
+					errs = append(errs, userErr(ConflictError{
+						Field:            outerArg.name,
+						Flag:             outerArg.flag,
+						Index:            outerArg.index,
+						ConflictingField: innerArg.name,
+						ConflictingFlag:  innerArg.flag,
+						ConflictingIndex: innerArg.index,
+					}, nil))
 				}
 			}
 		}
 	}
+	return errs
 }
 
-func checkForMissingMandatoryArgs(programArgs []arg, givenNonPositionalArgs []arg, givenPositionalArgs []arg) {
+func checkForMissingMandatoryArgs(programArgs []arg, givenNonPositionalArgs []givenArg, givenPositionalArgs []arg) []error {
+	errs := make([]error, 0)
 	givenArgs := make([]arg, 0)
-	givenArgs = append(givenArgs, givenNonPositionalArgs...)
+	for _, givenArg := range givenNonPositionalArgs {
+		givenArgs = append(givenArgs, givenArg.arg)
+	}
 	givenArgs = append(givenArgs, givenPositionalArgs...)
 
 outer:
@@ -600,26 +2277,29 @@ outer:
 				}
 			}
 			if arg.positional {
-				userErr(fmt.Sprintf("missing mandatory positional argument: %s", arg.name), programArgs)
+				errs = append(errs, userErr(MissingMandatoryError{Field: arg.name, Positional: true}, programArgs))
 			} else {
-				userErr(fmt.Sprintf("missing mandatory argument: %s", arg), programArgs)
+				errs = append(errs, userErr(MissingMandatoryError{Field: arg.name, Flag: arg.String()}, programArgs))
 			}
 		}
 	}
+	return errs
 }
 
-func checkForMultipleUse(givenNonPositionalArgs []arg) {
+func checkForMultipleUse(givenNonPositionalArgs []givenArg) []error {
+	errs := make([]error, 0)
 	seen := make(map[string]bool)
 	for _, arg := range givenNonPositionalArgs {
 		_, exists := seen[arg.name]
 		if !exists {
 			seen[arg.name] = true
 		} else {
-			if arg.kind != reflect.Slice {
-				userErr(fmt.Sprintf("multiple use of argument %s", arg), nil)
+			if !arg.isSlice() && !arg.isMap() && !arg.count && !isValue(arg.type_) {
+				errs = append(errs, userErr(MultipleUseError{Field: arg.name, Flag: arg.flag, Index: arg.index}, nil))
 			}
 		}
 	}
+	return errs
 }
 
 func parseTagValues(tag string) []string {
@@ -662,15 +2342,57 @@ func parseTagValues(tag string) []string {
 	return tagValues
 }
 
-func printHelp(args []arg, w io.Writer) {
+func (p *Parser) longValueSep() string {
+	if p.equalsHelp {
+		return "="
+	}
+	return " "
+}
+
+func (p *Parser) posixMode() bool {
+	switch p.posix {
+	case PosixOn:
+		return true
+	case PosixAuto:
+		_, ok := os.LookupEnv("POSIXLY_CORRECT")
+		return ok
+	default:
+		return false
+	}
+}
+
+func (p *Parser) warnDeprecated(arg arg, flag string) {
+	if arg.deprecated != "" {
+		fmt.Fprintf(p.stderr, "warning: %s is deprecated: %s\n", flag, arg.deprecated)
+	}
+}
+
+func unhideArgs(args []arg) []arg {
+	unhidden := make([]arg, 0, len(args))
+	for _, arg := range args {
+		arg.hidden = false
+		aliases := make([]alias, 0, len(arg.aliases))
+		for _, alias := range arg.aliases {
+			alias.hidden = false
+			aliases = append(aliases, alias)
+		}
+		arg.aliases = aliases
+		unhidden = append(unhidden, arg)
+	}
+	return unhidden
+}
+
+func (p *Parser) printHelp(args []arg, w io.Writer) {
 	buf := bytes.Buffer{}
 
-	if desc != "" {
-		fmt.Fprintf(&buf, "%s\n\n", desc)
+	args = filterArgs(args, func(arg arg) bool { return !arg.hidden })
+
+	if p.desc != "" {
+		fmt.Fprintf(&buf, "%s\n\n", p.desc)
 	}
 
 	var usageParts []string
-	usageParts = append(usageParts, prog)
+	usageParts = append(usageParts, p.prog)
 
 	for _, arg := range args {
 		if !arg.mandatory {
@@ -685,15 +2407,19 @@ func printHelp(args []arg, w io.Writer) {
 		}
 
 		var argSyntax string
-		if arg.long != "" {
-			argSyntax = fmt.Sprintf("--%s <%s>", arg.long, arg.name)
+		if arg.long != "" && arg.optionalValue != "" {
+			argSyntax = fmt.Sprintf("--%s[=<%s>]", arg.long, arg.valueName())
+		} else if arg.long != "" {
+			argSyntax = fmt.Sprintf("--%s%s<%s>", arg.long, p.longValueSep(), arg.valueName())
+		} else if arg.short != "" && arg.optionalValue != "" {
+			argSyntax = fmt.Sprintf("-%s[<%s>]", arg.short, arg.valueName())
 		} else if arg.short != "" {
-			argSyntax = fmt.Sprintf("-%s <%s>", arg.short, arg.name)
+			argSyntax = fmt.Sprintf("-%s <%s>", arg.short, arg.valueName())
 		} else {
-			developerErr("Either long or short name must be specified: " + arg.name)
+			panic(developerErr("Either long or short name must be specified: " + arg.name))
 		}
 
-		if arg.kind == reflect.Slice {
+		if arg.isSlice() {
 			argSyntax = argSyntax + "..."
 		}
 
@@ -711,7 +2437,7 @@ func printHelp(args []arg, w io.Writer) {
 			} else {
 				usagePart = "[" + arg.name + "]"
 			}
-			if arg.kind == reflect.Slice {
+			if arg.isSlice() {
 				usagePart += "..."
 			}
 			usageParts = append(usageParts, usagePart)
@@ -726,15 +2452,26 @@ func printHelp(args []arg, w io.Writer) {
 	maxLabelLen := 0
 	getLabel := func(arg arg) string {
 		var parts []string
-		if arg.short != "" {
-			parts = append(parts, "-"+arg.short)
+		for _, short := range arg.shortNames(false) {
+			parts = append(parts, "-"+short)
 		}
-		if arg.long != "" {
-			parts = append(parts, "--"+arg.long)
+		for _, long := range arg.longNames(false) {
+			parts = append(parts, "--"+long)
+		}
+		for _, long := range arg.negatedLongNames(false) {
+			parts = append(parts, "--"+long)
 		}
 		label := strings.Join(parts, ", ")
-		if arg.kind != reflect.Bool {
-			label += fmt.Sprintf(" <%s>", arg.name)
+		if arg.takesValue() {
+			if arg.long != "" && arg.optionalValue != "" {
+				label += fmt.Sprintf("[=<%s>]", arg.valueName())
+			} else if arg.optionalValue != "" {
+				label += fmt.Sprintf("[<%s>]", arg.valueName())
+			} else if arg.long != "" {
+				label += fmt.Sprintf("%s<%s>", p.longValueSep(), arg.valueName())
+			} else {
+				label += fmt.Sprintf(" <%s>", arg.valueName())
+			}
 		}
 		if len(label) > maxLabelLen {
 			maxLabelLen = len(label)
@@ -758,11 +2495,29 @@ func printHelp(args []arg, w io.Writer) {
 				hasRequired = true
 			}
 			desc := arg.desc
-			if arg.kind == reflect.Slice {
+			if arg.isSlice() {
 				desc += " (can be specified multiple times)"
 			}
+			if arg.isMap() {
+				desc += " (key=value, can be specified multiple times)"
+			}
+			if arg.sep != "" {
+				desc += fmt.Sprintf(" (separated by '%s')", arg.sep)
+			}
+			if choices := arg.allChoices(); len(choices) > 0 {
+				desc += fmt.Sprintf(" (one of: %s)", strings.Join(choices, ", "))
+			}
+			if arg.count {
+				desc += " (can be repeated)"
+			}
+			if arg.optionalValue != "" {
+				desc += fmt.Sprintf(" (if value omitted: %s)", arg.optionalValue)
+			}
+			if arg.deprecated != "" {
+				desc += fmt.Sprintf(" (deprecated: %s)", arg.deprecated)
+			}
 			if arg.defaultValue != "" {
-				desc += fmt.Sprintf(" (default: %s)", arg.defaultValue)
+				desc += fmt.Sprintf(" (default: %s)", arg.defaultString())
 			}
 			fmt.Fprintf(&buf, "  %-*s  %s\n", maxLabelLen, labels[arg.name], desc)
 		}
@@ -780,11 +2535,29 @@ func printHelp(args []arg, w io.Writer) {
 				hasOptional = true
 			}
 			additionalDesciptions := make([]string, 0)
-			if arg.kind == reflect.Slice {
+			if arg.isSlice() {
 				additionalDesciptions = append(additionalDesciptions, "can be specified multiple times")
 			}
+			if arg.isMap() {
+				additionalDesciptions = append(additionalDesciptions, "key=value, can be specified multiple times")
+			}
+			if arg.sep != "" {
+				additionalDesciptions = append(additionalDesciptions, fmt.Sprintf("separated by '%s'", arg.sep))
+			}
+			if choices := arg.allChoices(); len(choices) > 0 {
+				additionalDesciptions = append(additionalDesciptions, "one of: "+strings.Join(choices, ", "))
+			}
+			if arg.count {
+				additionalDesciptions = append(additionalDesciptions, "can be repeated")
+			}
+			if arg.optionalValue != "" {
+				additionalDesciptions = append(additionalDesciptions, "if value omitted: "+arg.optionalValue)
+			}
+			if arg.deprecated != "" {
+				additionalDesciptions = append(additionalDesciptions, "deprecated: "+arg.deprecated)
+			}
 			if arg.defaultValue != "" {
-				additionalDesciptions = append(additionalDesciptions, "default: "+arg.defaultValue)
+				additionalDesciptions = append(additionalDesciptions, "default: "+arg.defaultString())
 			}
 			var desc string
 			if len(additionalDesciptions) > 0 {
@@ -811,11 +2584,17 @@ func printHelp(args []arg, w io.Writer) {
 			if arg.mandatory {
 				additionalDesciptions = append(additionalDesciptions, "required")
 			}
-			if arg.kind == reflect.Slice {
+			if arg.isSlice() {
 				additionalDesciptions = append(additionalDesciptions, "can be specified multiple times")
 			}
+			if arg.sep != "" {
+				additionalDesciptions = append(additionalDesciptions, fmt.Sprintf("separated by '%s'", arg.sep))
+			}
+			if choices := arg.allChoices(); len(choices) > 0 {
+				additionalDesciptions = append(additionalDesciptions, "one of: "+strings.Join(choices, ", "))
+			}
 			if arg.defaultValue != "" {
-				additionalDesciptions = append(additionalDesciptions, "default: "+arg.defaultValue)
+				additionalDesciptions = append(additionalDesciptions, "default: "+arg.defaultString())
 			}
 			var desc string
 			if len(additionalDesciptions) > 0 {
@@ -846,9 +2625,9 @@ func printHelp(args []arg, w io.Writer) {
 		fmt.Fprintln(&buf)
 	}
 
-	if example != "" {
+	if p.example != "" {
 		cfmt.Fprint(&buf, "#B{Example:}\n")
-		lines := strings.Split(example, "\n")
+		lines := strings.Split(p.example, "\n")
 		for _, line := range lines {
 			fmt.Fprintf(&buf, "  %s\n", line)
 		}
@@ -857,12 +2636,75 @@ func printHelp(args []arg, w io.Writer) {
 	fmt.Fprint(w, strings.TrimSpace(buf.String())+"\n")
 }
 
-func developerErr(msg string) {
-	panic(developerError{msg})
+func invalidValueErr(arg arg, flag string, value string, index int, err error) error {
+	var developerErr DeveloperError
+	if errors.As(err, &developerErr) {
+		return err
+	}
+	return userErr(InvalidValueError{Field: arg.name, Flag: flag, Value: value, Index: index, Err: err}, nil)
+}
+
+func unknownArgumentErr(flag string, index int, programArgs []arg) error {
+	candidates := make([]string, 0)
+	for _, arg := range programArgs {
+		if arg.positional {
+			continue
+		}
+		if arg.hidden {
+			continue
+		}
+		for _, long := range append(arg.longNames(false), arg.negatedLongNames(false)...) {
+			candidates = append(candidates, "--"+long)
+		}
+		for _, short := range arg.shortNames(false) {
+			candidates = append(candidates, "-"+short)
+		}
+	}
+	suggestion := suggest(flag, candidates)
+	if suggestion != "" {
+		return userErr(UnknownArgumentError{Flag: flag, Index: index, Suggestion: suggestion}, nil)
+	}
+	return userErr(UnknownArgumentError{Flag: flag, Index: index}, programArgs)
+}
+
+func unknownCommandErr(value string, index int, programArgs []arg) error {
+	candidates := make([]string, 0)
+	for _, arg := range programArgs {
+		if arg.cmdopt {
+			candidates = append(candidates, toKebabCase(arg.name))
+		}
+	}
+	suggestion := suggest(value, candidates)
+	if suggestion != "" {
+		return userErr(UnknownCommandError{Value: value, Index: index, Suggestion: suggestion}, nil)
+	}
+	return userErr(UnknownCommandError{Value: value, Index: index}, programArgs)
+}
+
+// joinUserErrs joins the collected user errors into a single UserError. The
+// usage block of the first error that has one is kept.
+func joinUserErrs(userErrs []error, parser *Parser) error {
+	if len(userErrs) == 1 {
+		return userErrs[0]
+	}
+	joined := UserError{err: errors.Join(userErrs...), parser: parser}
+	for _, err := range userErrs {
+		userErr := err.(UserError)
+		if userErr.args != nil {
+			joined.args = userErr.args
+			joined.parser = userErr.parser
+			break
+		}
+	}
+	return joined
+}
+
+func developerErr(msg string) error {
+	return DeveloperError{msg}
 }
 
-func userErr(msg string, args []arg) {
-	panic(userError{msg: msg, args: args})
+func userErr(err error, args []arg) error {
+	return UserError{err: err, args: args}
 }
 
 func toKebabCase(s string) string {
@@ -893,6 +2735,59 @@ func kebabToPascalCase(s string) string {
 	return strings.Join(words, "")
 }
 
+// suggest returns the candidate closest to given, or an empty string if none
+// is close enough to be a likely typo. Candidates that differ only in case are
+// always suggested, otherwise up to a third of the characters may differ.
+func suggest(given string, candidates []string) string {
+	name := strings.TrimLeft(given, "-")
+	maxDist := len(name) / 3
+	suggestion := ""
+	suggestionDist := 0
+	for _, candidate := range candidates {
+		if candidate == given {
+			continue
+		}
+		if strings.EqualFold(candidate, given) {
+			return candidate
+		}
+		dist := editDistance(given, candidate)
+		if dist <= maxDist && (suggestion == "" || dist < suggestionDist) {
+			suggestion = candidate
+			suggestionDist = dist
+		}
+	}
+	return suggestion
+}
+
+// editDistance returns the optimal string alignment distance between a and b,
+// i.e. the Levenshtein distance where swapping two adjacent characters counts
+// as a single edit.
+func editDistance(a, b string) int {
+	ra := []rune(a)
+	rb := []rune(b)
+	d := make([][]int, len(ra)+1)
+	for i := range d {
+		d[i] = make([]int, len(rb)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(ra)][len(rb)]
+}
+
 func filterArgs(args []arg, predicate func(arg arg) bool) []arg {
 	filtered := make([]arg, 0)
 	for _, arg := range args {
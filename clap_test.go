@@ -1,9 +1,20 @@
 package clap
 
 import (
+	"bytes"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/netip"
 	"os"
+	"path/filepath"
 	"reflect"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 )
@@ -22,7 +33,9 @@ func TestMandatoryArg(t *testing.T) {
 		}
 
 		args := Args{}
-		parse(os.Args, &args)
+		if err := New().parse(os.Args, 0, &args); err != nil {
+			t.Fatal(err)
+		}
 
 		if args.Name != "Alice" {
 			t.Fatalf("expected 'Alice', got '%s'", args.Name)
@@ -37,7 +50,9 @@ func TestDefaultValue(t *testing.T) {
 		}
 
 		args := Args{}
-		parse(os.Args, &args)
+		if err := New().parse(os.Args, 0, &args); err != nil {
+			t.Fatal(err)
+		}
 
 		if args.Salary != 9999 {
 			t.Fatalf("expected default 9999, got %d", args.Salary)
@@ -52,7 +67,9 @@ func TestShortAndLongArgs(t *testing.T) {
 		}
 
 		args := Args{}
-		parse(os.Args, &args)
+		if err := New().parse(os.Args, 0, &args); err != nil {
+			t.Fatal(err)
+		}
 
 		if !args.FullTime {
 			t.Fatal("expected FullTime to be true")
@@ -65,7 +82,9 @@ func TestShortAndLongArgs(t *testing.T) {
 		}
 
 		args := Args{}
-		parse(os.Args, &args)
+		if err := New().parse(os.Args, 0, &args); err != nil {
+			t.Fatal(err)
+		}
 
 		if !args.FullTime {
 			t.Fatal("expected FullTime to be true via long argument")
@@ -82,7 +101,9 @@ func TestShortGrouped(t *testing.T) {
 		}
 
 		args := Args{}
-		parse(os.Args, &args)
+		if err := New().parse(os.Args, 0, &args); err != nil {
+			t.Fatal(err)
+		}
 
 		if !args.A || !args.B || !args.C {
 			t.Fatal("expected all to be true")
@@ -92,19 +113,15 @@ func TestShortGrouped(t *testing.T) {
 
 func TestConflictingArgs(t *testing.T) {
 	withArgs([]string{"prog", "-F", "-P"}, func() {
-		defer func() {
-			if r := recover(); r == nil {
-				t.Fatal("expected conflict panic, got none")
-			}
-		}()
-
 		type Args struct {
 			FullTime bool `clap:"short=F,long=full-time,conflicts=PartTime"`
 			PartTime bool `clap:"short=P"`
 		}
 
 		args := Args{}
-		parse(os.Args, &args)
+		if err := New().parse(os.Args, 0, &args); err == nil {
+			t.Fatal("expected conflict error, got none")
+		}
 	})
 }
 
@@ -115,7 +132,9 @@ func TestStringArg(t *testing.T) {
 		}
 
 		args := Args{}
-		parse(os.Args, &args)
+		if err := New().parse(os.Args, 0, &args); err != nil {
+			t.Fatal(err)
+		}
 
 		if args.Email != "test@company.com" {
 			t.Fatalf("expected email to be set, got '%s'", args.Email)
@@ -130,7 +149,9 @@ func TestStringSliceArg(t *testing.T) {
 		}
 
 		args := Args{}
-		parse(os.Args, &args)
+		if err := New().parse(os.Args, 0, &args); err != nil {
+			t.Fatal(err)
+		}
 
 		if len(args.Notify) != 2 || args.Notify[0] != "#eng" || args.Notify[1] != "#ops" {
 			t.Fatalf("unexpected slice values: %+v", args.Notify)
@@ -146,7 +167,9 @@ func TestPositionalArgs(t *testing.T) {
 		}
 
 		args := Args{}
-		parse(os.Args, &args)
+		if err := New().parse(os.Args, 0, &args); err != nil {
+			t.Fatal(err)
+		}
 
 		if args.EmployeeID != "EMP123" {
 			t.Fatalf("expected EmployeeID 'EMP123', got '%s'", args.EmployeeID)
@@ -165,7 +188,9 @@ func TestPositionalArgsDoubleDash(t *testing.T) {
 		}
 
 		args := Args{}
-		parse(os.Args, &args)
+		if err := New().parse(os.Args, 0, &args); err != nil {
+			t.Fatal(err)
+		}
 
 		if args.EmployeeID != "-EMP123-" {
 			t.Fatalf("expected EmployeeID 'EMP123', got '%s'", args.EmployeeID)
@@ -184,7 +209,9 @@ func TestPositionalSliceArgs(t *testing.T) {
 		}
 
 		args := Args{}
-		parse(os.Args, &args)
+		if err := New().parse(os.Args, 0, &args); err != nil {
+			t.Fatal(err)
+		}
 
 		if args.EmployeeID != "EMP123" {
 			t.Fatalf("expected EmployeeID 'EMP123', got '%s'", args.EmployeeID)
@@ -204,7 +231,9 @@ func TestPositionalDefault(t *testing.T) {
 		}
 
 		args := Args{}
-		parse(os.Args, &args)
+		if err := New().parse(os.Args, 0, &args); err != nil {
+			t.Fatal(err)
+		}
 
 		if args.Department != "Design" {
 			t.Fatalf("expected default Department 'Design', got '%s'", args.Department)
@@ -219,7 +248,9 @@ func TestBoolDefaultFalse(t *testing.T) {
 		}
 
 		args := Args{}
-		parse(os.Args, &args)
+		if err := New().parse(os.Args, 0, &args); err != nil {
+			t.Fatal(err)
+		}
 
 		if args.Apprenticeship {
 			t.Fatal("expected Apprenticeship to be false by default")
@@ -229,35 +260,27 @@ func TestBoolDefaultFalse(t *testing.T) {
 
 func TestMissingMandatoryPanics(t *testing.T) {
 	withArgs([]string{"prog"}, func() {
-		defer func() {
-			if r := recover(); r == nil {
-				t.Fatal("expected panic for missing mandatory argument")
-			}
-		}()
-
 		type Args struct {
 			Name string `clap:"mandatory"`
 		}
 
 		args := Args{}
-		parse(os.Args, &args)
+		if err := New().parse(os.Args, 0, &args); err == nil {
+			t.Fatal("expected error for missing mandatory argument")
+		}
 	})
 }
 
 func TestMissingShortAndLongPanics(t *testing.T) {
 	withArgs([]string{"prog"}, func() {
-		defer func() {
-			if r := recover(); r == nil {
-				t.Fatal("expected panic for missing short and long name")
-			}
-		}()
-
 		type Args struct {
 			Name string `clap:"mandatory,short=,long="`
 		}
 
 		args := Args{}
-		parse(os.Args, &args)
+		if err := New().parse(os.Args, 0, &args); err == nil {
+			t.Fatal("expected error for missing short and long name")
+		}
 	})
 }
 
@@ -268,7 +291,9 @@ func TestDurationArg(t *testing.T) {
 		}
 
 		args := Args{}
-		parse(os.Args, &args)
+		if err := New().parse(os.Args, 0, &args); err != nil {
+			t.Fatal(err)
+		}
 
 		if fmt.Sprintf("%v", args.Duration) != "1h12m2s" {
 			t.FailNow()
@@ -283,7 +308,9 @@ func TestDefaultValueWithBackslash(t *testing.T) {
 		}
 
 		args := Args{}
-		parse(os.Args, &args)
+		if err := New().parse(os.Args, 0, &args); err != nil {
+			t.Fatal(err)
+		}
 
 		if fmt.Sprintf("%v", args.Path) != "C:\\Users\\user\\My Documents\\" {
 			t.Fatal(args.Path)
@@ -308,7 +335,9 @@ func TestSubCommands1(t *testing.T) {
 		}
 
 		args := Args{}
-		parse(os.Args, &args)
+		if err := New().parse(os.Args, 0, &args); err != nil {
+			t.Fatal(err)
+		}
 
 		if !args.Insecure {
 			t.Fatal("Expected Insecure == true")
@@ -336,7 +365,9 @@ func TestSubCommands2(t *testing.T) {
 		}
 
 		args := Args{}
-		parse(os.Args, &args)
+		if err := New().parse(os.Args, 0, &args); err != nil {
+			t.Fatal(err)
+		}
 
 		if !args.Insecure {
 			t.Fatal("Expected Insecure == true")
@@ -365,7 +396,9 @@ func TestSubSubCommands(t *testing.T) {
 		}
 
 		args := Args{}
-		parse(os.Args, &args)
+		if err := New().parse(os.Args, 0, &args); err != nil {
+			t.Fatal(err)
+		}
 
 		if !args.Files.List.ShowHidden {
 			t.Fatalf("Expected Files.List.Hidden == true")
@@ -375,54 +408,1782 @@ func TestSubSubCommands(t *testing.T) {
 
 func TestCmdNotAnyFail(t *testing.T) {
 	withArgs([]string{"prog", "ls"}, func() {
-		defer func() {
-			if r := recover(); r == nil {
-				t.Fatal("expected panic, got none")
-			}
-		}()
-
 		type Args struct {
 			Command string `clap:"cmd"`
 			Ls      any    `clap:"cmdopt"`
 		}
 
 		args := Args{}
-		parse(os.Args, &args)
+		if err := New().parse(os.Args, 0, &args); err == nil {
+			t.Fatal("expected error, got none")
+		}
 	})
 }
 
 func TestCmdOptNotAnyFail(t *testing.T) {
 	withArgs([]string{"prog", "ls"}, func() {
-		defer func() {
-			if r := recover(); r == nil {
-				t.Fatal("expected panic, got none")
-			}
-		}()
-
 		type Args struct {
 			Command any    `clap:"cmd"`
 			Ls      string `clap:"cmdopt"`
 		}
 
 		args := Args{}
-		parse(os.Args, &args)
+		if err := New().parse(os.Args, 0, &args); err == nil {
+			t.Fatal("expected error, got none")
+		}
 	})
 }
 
 func TestCmdOptEmptyStructFail(t *testing.T) {
 	withArgs([]string{"prog", "ls"}, func() {
-		defer func() {
-			if r := recover(); r == nil {
-				t.Fatal("expected panic, got none")
-			}
-		}()
-
 		type Args struct {
 			Command any      `clap:"cmd"`
 			Ls      struct{} `clap:"cmdopt"`
 		}
 
 		args := Args{}
-		parse(os.Args, &args)
+		if err := New().parse(os.Args, 0, &args); err == nil {
+			t.Fatal("expected error, got none")
+		}
 	})
 }
+
+func TestParseArgsUserError(t *testing.T) {
+	type Args struct {
+		Name string
+	}
+
+	args := Args{}
+	err := ParseArgs([]string{"--unknown"}, &args)
+
+	var userErr UserError
+	if !errors.As(err, &userErr) {
+		t.Fatalf("expected UserError, got %v", err)
+	}
+}
+
+func TestParseArgsDeveloperError(t *testing.T) {
+	type Args struct {
+		Name string `clap:"invalid"`
+	}
+
+	args := Args{}
+	err := ParseArgs([]string{}, &args)
+
+	var developerErr DeveloperError
+	if !errors.As(err, &developerErr) {
+		t.Fatalf("expected DeveloperError, got %v", err)
+	}
+}
+
+func TestMustParsePanics(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil {
+			t.Fatal("expected panic, got none")
+		}
+	}()
+
+	type Args struct {
+		Name string `clap:"mandatory"`
+	}
+
+	args := Args{}
+	MustParse([]string{}, &args)
+}
+
+func TestParserHelpWriter(t *testing.T) {
+	type Args struct {
+		Name string `clap:"desc='The name'"`
+	}
+
+	stdout := bytes.Buffer{}
+	p := New(WithProg("myprog"), WithDescription("My description"), WithStdout(&stdout))
+
+	args := Args{}
+	if err := p.ParseArgs([]string{"--help"}, &args); !errors.Is(err, ErrHelp) {
+		t.Fatalf("expected ErrHelp, got %v", err)
+	}
+
+	help := stdout.String()
+	if !strings.Contains(help, "myprog") || !strings.Contains(help, "My description") {
+		t.Fatalf("unexpected help message: %s", help)
+	}
+}
+
+func TestParserSubCommandKeepsProg(t *testing.T) {
+	type Args struct {
+		Command any `clap:"cmd,mandatory"`
+		Add     any `clap:"cmdopt"`
+	}
+
+	p := New(WithProg("myprog"), WithDescription("My description"))
+
+	args := Args{}
+	if err := p.ParseArgs([]string{"add"}, &args); err != nil {
+		t.Fatal(err)
+	}
+
+	if p.prog != "myprog" || p.desc != "My description" {
+		t.Fatalf("expected parser to be unchanged, got prog '%s' and desc '%s'", p.prog, p.desc)
+	}
+}
+
+func TestParsersConcurrent(t *testing.T) {
+	type Args struct {
+		Name string
+	}
+
+	for _, name := range []string{"Alice", "Bob"} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			p := New(WithProg(name))
+
+			args := Args{}
+			if err := p.ParseArgs([]string{"--name", name}, &args); err != nil {
+				t.Fatal(err)
+			}
+
+			if args.Name != name {
+				t.Fatalf("expected '%s', got '%s'", name, args.Name)
+			}
+		})
+	}
+}
+
+func TestUnknownArgumentError(t *testing.T) {
+	type Args struct {
+		Verbose bool
+	}
+
+	args := Args{}
+	err := New().ParseArgs([]string{"-v", "--verbos"}, &args)
+
+	var unknownErr UnknownArgumentError
+	if !errors.As(err, &unknownErr) {
+		t.Fatalf("expected UnknownArgumentError, got %v", err)
+	}
+	if unknownErr.Flag != "--verbos" || unknownErr.Index != 1 {
+		t.Fatalf("unexpected error: %+v", unknownErr)
+	}
+}
+
+func TestInvalidValueError(t *testing.T) {
+	type Args struct {
+		Salary int
+	}
+
+	args := Args{}
+	err := New().ParseArgs([]string{"--salary", "abc"}, &args)
+
+	var invalidErr InvalidValueError
+	if !errors.As(err, &invalidErr) {
+		t.Fatalf("expected InvalidValueError, got %v", err)
+	}
+	if invalidErr.Field != "Salary" || invalidErr.Flag != "--salary" || invalidErr.Value != "abc" || invalidErr.Index != 1 {
+		t.Fatalf("unexpected error: %+v", invalidErr)
+	}
+}
+
+func TestConflictError(t *testing.T) {
+	type Args struct {
+		FullTime bool `clap:"short=F,conflicts=PartTime"`
+		PartTime bool `clap:"short=P"`
+	}
+
+	args := Args{}
+	err := New().ParseArgs([]string{"-F", "--part-time"}, &args)
+
+	var conflictErr ConflictError
+	if !errors.As(err, &conflictErr) {
+		t.Fatalf("expected ConflictError, got %v", err)
+	}
+	if conflictErr.Flag != "-F" || conflictErr.ConflictingFlag != "--part-time" || conflictErr.ConflictingIndex != 1 {
+		t.Fatalf("unexpected error: %+v", conflictErr)
+	}
+}
+
+func TestMissingMandatoryError(t *testing.T) {
+	type Args struct {
+		Name string `clap:"positional,mandatory"`
+	}
+
+	args := Args{}
+	err := New().ParseArgs([]string{}, &args)
+
+	var missingErr MissingMandatoryError
+	if !errors.As(err, &missingErr) {
+		t.Fatalf("expected MissingMandatoryError, got %v", err)
+	}
+	if missingErr.Field != "Name" || !missingErr.Positional {
+		t.Fatalf("unexpected error: %+v", missingErr)
+	}
+}
+
+func TestUnknownCommandErrorInSubCommand(t *testing.T) {
+	type Args struct {
+		Command any `clap:"cmd,mandatory"`
+
+		Files struct {
+			Command any `clap:"cmd,mandatory"`
+			List    any `clap:"cmdopt"`
+		} `clap:"cmdopt"`
+	}
+
+	args := Args{}
+	err := New().ParseArgs([]string{"files", "lsit"}, &args)
+
+	var unknownErr UnknownCommandError
+	if !errors.As(err, &unknownErr) {
+		t.Fatalf("expected UnknownCommandError, got %v", err)
+	}
+	if unknownErr.Value != "lsit" || unknownErr.Index != 1 {
+		t.Fatalf("unexpected error: %+v", unknownErr)
+	}
+}
+
+func TestSuggestUnknownArgument(t *testing.T) {
+	type Args struct {
+		Verbose bool
+		Version bool `clap:"short=V"`
+	}
+
+	args := Args{}
+	err := New().ParseArgs([]string{"--verbos"}, &args)
+
+	var unknownErr UnknownArgumentError
+	if !errors.As(err, &unknownErr) {
+		t.Fatalf("expected UnknownArgumentError, got %v", err)
+	}
+	if unknownErr.Suggestion != "--verbose" {
+		t.Fatalf("expected suggestion '--verbose', got '%s'", unknownErr.Suggestion)
+	}
+	if err.Error() != "unknown argument: --verbos, did you mean --verbose?" {
+		t.Fatalf("unexpected message: %s", err)
+	}
+}
+
+func TestSuggestUnknownCommand(t *testing.T) {
+	type Args struct {
+		Command any `clap:"cmd,mandatory"`
+		List    any `clap:"cmdopt"`
+		Add     any `clap:"cmdopt"`
+	}
+
+	args := Args{}
+	err := New().ParseArgs([]string{"lsit"}, &args)
+
+	var unknownErr UnknownCommandError
+	if !errors.As(err, &unknownErr) {
+		t.Fatalf("expected UnknownCommandError, got %v", err)
+	}
+	if unknownErr.Suggestion != "list" {
+		t.Fatalf("expected suggestion 'list', got '%s'", unknownErr.Suggestion)
+	}
+}
+
+func TestSuggestNothingForUnrelated(t *testing.T) {
+	if s := suggest("--xyz", []string{"--verbose", "--version", "-v"}); s != "" {
+		t.Fatalf("expected no suggestion, got '%s'", s)
+	}
+	if s := suggest("-x", []string{"-v", "-h"}); s != "" {
+		t.Fatalf("expected no suggestion, got '%s'", s)
+	}
+}
+
+func TestAllErrors(t *testing.T) {
+	type Args struct {
+		Name     string `clap:"mandatory"`
+		Salary   int
+		FullTime bool `clap:"short=F,conflicts=PartTime"`
+		PartTime bool `clap:"short=P"`
+	}
+
+	args := Args{}
+	err := New(WithAllErrors()).ParseArgs([]string{"--unknown", "--salary", "abc", "-F", "-P"}, &args)
+
+	var userErr UserError
+	if !errors.As(err, &userErr) {
+		t.Fatalf("expected UserError, got %v", err)
+	}
+	if userErr.args == nil {
+		t.Fatal("expected usage to be kept")
+	}
+
+	var unknownErr UnknownArgumentError
+	var invalidErr InvalidValueError
+	var conflictErr ConflictError
+	var missingErr MissingMandatoryError
+	if !errors.As(err, &unknownErr) || !errors.As(err, &invalidErr) || !errors.As(err, &conflictErr) || !errors.As(err, &missingErr) {
+		t.Fatalf("expected all errors to be reported, got:\n%v", err)
+	}
+	if lines := strings.Split(err.Error(), "\n"); len(lines) != 4 {
+		t.Fatalf("expected 4 errors, got %d:\n%v", len(lines), err)
+	}
+}
+
+func TestFirstErrorOnlyByDefault(t *testing.T) {
+	type Args struct {
+		Name   string `clap:"mandatory"`
+		Salary int
+	}
+
+	args := Args{}
+	err := New().ParseArgs([]string{"--unknown", "--salary", "abc"}, &args)
+
+	if err == nil || strings.Contains(err.Error(), "\n") {
+		t.Fatalf("expected single error, got %v", err)
+	}
+}
+
+func TestLongEqualsValue(t *testing.T) {
+	type Args struct {
+		Name     string
+		Notify   []string `clap:"short=N"`
+		Duration time.Duration
+	}
+
+	args := Args{}
+	err := New().ParseArgs([]string{"--name=-Alice=Bob", "--notify=#eng", "--notify=#ops", "--duration=1m"}, &args)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if args.Name != "-Alice=Bob" {
+		t.Fatalf("expected '-Alice=Bob', got '%s'", args.Name)
+	}
+	if !reflect.DeepEqual(args.Notify, []string{"#eng", "#ops"}) {
+		t.Fatalf("unexpected slice values: %+v", args.Notify)
+	}
+	if args.Duration != time.Minute {
+		t.Fatalf("expected 1m, got %v", args.Duration)
+	}
+}
+
+func TestLongValueStartingWithDash(t *testing.T) {
+	type Args struct {
+		Name string
+	}
+
+	args := Args{}
+	err := New().ParseArgs([]string{"--name", "-Alice"}, &args)
+
+	var missingErr MissingValueError
+	if !errors.As(err, &missingErr) {
+		t.Fatalf("expected MissingValueError, got %v", err)
+	}
+}
+
+func TestEqualsHelp(t *testing.T) {
+	type Args struct {
+		Name string `clap:"mandatory"`
+	}
+
+	stdout := bytes.Buffer{}
+	args := Args{}
+	err := New(WithEqualsHelp(), WithStdout(&stdout)).ParseArgs([]string{"-h"}, &args)
+	if !errors.Is(err, ErrHelp) {
+		t.Fatalf("expected ErrHelp, got %v", err)
+	}
+
+	if !strings.Contains(stdout.String(), "--name=<Name>") {
+		t.Fatalf("expected --name=<Name> in help, got:\n%s", stdout.String())
+	}
+}
+
+func TestShortAttachedValue(t *testing.T) {
+	type Args struct {
+		Verbose bool
+		Output  string
+	}
+
+	args := Args{}
+	if err := New().ParseArgs([]string{"-vofile.txt"}, &args); err != nil {
+		t.Fatal(err)
+	}
+
+	if !args.Verbose || args.Output != "file.txt" {
+		t.Fatalf("unexpected values: %+v", args)
+	}
+}
+
+func TestShortValueAtEndOfGroup(t *testing.T) {
+	type Args struct {
+		Verbose bool
+		Output  string
+		Names   []string `clap:"short=N"`
+	}
+
+	args := Args{}
+	if err := New().ParseArgs([]string{"-vo", "file.txt", "-NAlice", "-N", "Bob"}, &args); err != nil {
+		t.Fatal(err)
+	}
+
+	if !args.Verbose || args.Output != "file.txt" {
+		t.Fatalf("unexpected values: %+v", args)
+	}
+	if !reflect.DeepEqual(args.Names, []string{"Alice", "Bob"}) {
+		t.Fatalf("unexpected slice values: %+v", args.Names)
+	}
+}
+
+func TestNegatableBool(t *testing.T) {
+	type Args struct {
+		Color bool `clap:"negatable,default=true"`
+	}
+
+	args := Args{}
+	if err := New().ParseArgs([]string{}, &args); err != nil {
+		t.Fatal(err)
+	}
+	if !args.Color {
+		t.Fatal("expected Color to default to true")
+	}
+
+	args = Args{}
+	if err := New().ParseArgs([]string{"--no-color"}, &args); err != nil {
+		t.Fatal(err)
+	}
+	if args.Color {
+		t.Fatal("expected Color to be false")
+	}
+}
+
+func TestBoolEqualsValue(t *testing.T) {
+	type Args struct {
+		Color bool `clap:"default=true"`
+	}
+
+	args := Args{}
+	if err := New().ParseArgs([]string{"--color=false"}, &args); err != nil {
+		t.Fatal(err)
+	}
+	if args.Color {
+		t.Fatal("expected Color to be false")
+	}
+
+	args = Args{}
+	err := New().ParseArgs([]string{"--color=maybe"}, &args)
+
+	var invalidErr InvalidValueError
+	if !errors.As(err, &invalidErr) {
+		t.Fatalf("expected InvalidValueError, got %v", err)
+	}
+}
+
+func TestNegatableMultipleUse(t *testing.T) {
+	type Args struct {
+		Color bool `clap:"negatable"`
+	}
+
+	args := Args{}
+	err := New().ParseArgs([]string{"--color", "--no-color"}, &args)
+
+	var multipleErr MultipleUseError
+	if !errors.As(err, &multipleErr) {
+		t.Fatalf("expected MultipleUseError, got %v", err)
+	}
+	if multipleErr.Flag != "--no-color" {
+		t.Fatalf("unexpected error: %+v", multipleErr)
+	}
+}
+
+func TestNegatableNotBoolFail(t *testing.T) {
+	type Args struct {
+		Name string `clap:"negatable"`
+	}
+
+	args := Args{}
+	err := New().ParseArgs([]string{}, &args)
+
+	var developerErr DeveloperError
+	if !errors.As(err, &developerErr) {
+		t.Fatalf("expected DeveloperError, got %v", err)
+	}
+}
+
+func TestOptionalValue(t *testing.T) {
+	type Args struct {
+		Color string   `clap:"optional_value=auto,default=never"`
+		Files []string `clap:"positional"`
+	}
+
+	tests := []struct {
+		args  []string
+		color string
+		files []string
+	}{
+		{[]string{}, "never", nil},
+		{[]string{"--color", "a.txt"}, "auto", []string{"a.txt"}},
+		{[]string{"--color=always", "a.txt"}, "always", []string{"a.txt"}},
+		{[]string{"-c", "a.txt"}, "auto", []string{"a.txt"}},
+		{[]string{"-calways"}, "always", nil},
+	}
+
+	for _, test := range tests {
+		args := Args{}
+		if err := New().ParseArgs(test.args, &args); err != nil {
+			t.Fatal(err)
+		}
+		if args.Color != test.color || !reflect.DeepEqual(args.Files, test.files) {
+			t.Fatalf("%v: unexpected values: %+v", test.args, args)
+		}
+	}
+}
+
+func TestOptionalValueHelp(t *testing.T) {
+	type Args struct {
+		Color string `clap:"optional_value=auto"`
+	}
+
+	stdout := bytes.Buffer{}
+	args := Args{}
+	err := New(WithStdout(&stdout)).ParseArgs([]string{"--help"}, &args)
+	if !errors.Is(err, ErrHelp) {
+		t.Fatalf("expected ErrHelp, got %v", err)
+	}
+
+	if !strings.Contains(stdout.String(), "-c, --color[=<Color>]") {
+		t.Fatalf("expected --color[=<Color>] in help, got:\n%s", stdout.String())
+	}
+}
+
+func TestAbbreviations(t *testing.T) {
+	type Args struct {
+		Verbose bool
+		Version bool `clap:"short=V"`
+		Name    string
+	}
+
+	args := Args{}
+	if err := New(WithAbbreviations()).ParseArgs([]string{"--verb", "--na=Alice"}, &args); err != nil {
+		t.Fatal(err)
+	}
+	if !args.Verbose || args.Name != "Alice" {
+		t.Fatalf("unexpected values: %+v", args)
+	}
+
+	args = Args{}
+	err := New(WithAbbreviations()).ParseArgs([]string{"--ver"}, &args)
+
+	var ambiguousErr AmbiguousArgumentError
+	if !errors.As(err, &ambiguousErr) {
+		t.Fatalf("expected AmbiguousArgumentError, got %v", err)
+	}
+	if err.Error() != "ambiguous option: --ver, could be --verbose or --version" {
+		t.Fatalf("unexpected message: %s", err)
+	}
+
+	args = Args{}
+	if err := New().ParseArgs([]string{"--verb"}, &args); err == nil {
+		t.Fatal("expected abbreviations to be disabled by default")
+	}
+}
+
+func TestAbbreviatedHelp(t *testing.T) {
+	type Args struct {
+		Name string
+	}
+
+	args := Args{}
+	err := New(WithAbbreviations(), WithStdout(io.Discard)).ParseArgs([]string{"--he"}, &args)
+	if !errors.Is(err, ErrHelp) {
+		t.Fatalf("expected ErrHelp, got %v", err)
+	}
+}
+
+func TestCount(t *testing.T) {
+	type Args struct {
+		Verbose int  `clap:"count"`
+		Quiet   bool `clap:"short=q"`
+	}
+
+	tests := []struct {
+		args    []string
+		verbose int
+	}{
+		{[]string{}, 0},
+		{[]string{"-v"}, 1},
+		{[]string{"-vvv"}, 3},
+		{[]string{"-vqv", "--verbose"}, 3},
+		{[]string{"--verbose=5"}, 5},
+	}
+
+	for _, test := range tests {
+		args := Args{}
+		if err := New().ParseArgs(test.args, &args); err != nil {
+			t.Fatal(err)
+		}
+		if args.Verbose != test.verbose {
+			t.Fatalf("%v: expected %d, got %d", test.args, test.verbose, args.Verbose)
+		}
+	}
+}
+
+func TestCountHelp(t *testing.T) {
+	type Args struct {
+		Verbose int `clap:"count,desc='Be verbose'"`
+	}
+
+	stdout := bytes.Buffer{}
+	args := Args{}
+	err := New(WithStdout(&stdout)).ParseArgs([]string{"--help"}, &args)
+	if !errors.Is(err, ErrHelp) {
+		t.Fatalf("expected ErrHelp, got %v", err)
+	}
+
+	if !strings.Contains(stdout.String(), "-v, --verbose  Be verbose (can be repeated)") {
+		t.Fatalf("unexpected help:\n%s", stdout.String())
+	}
+}
+
+func TestSliceSep(t *testing.T) {
+	type Args struct {
+		Tags  []string `clap:"sep=','"`
+		Ports []int    `clap:"positional,sep=:"`
+	}
+
+	args := Args{}
+	if err := New().ParseArgs([]string{"--tags", "a,b\\,c", "-t", "d", "80:443", "8080"}, &args); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(args.Tags, []string{"a", "b,c", "d"}) {
+		t.Fatalf("unexpected slice values: %+v", args.Tags)
+	}
+	if !reflect.DeepEqual(args.Ports, []int{80, 443, 8080}) {
+		t.Fatalf("unexpected slice values: %+v", args.Ports)
+	}
+}
+
+func TestSliceSepNotSliceFail(t *testing.T) {
+	type Args struct {
+		Name string `clap:"sep=','"`
+	}
+
+	args := Args{}
+	err := New().ParseArgs([]string{}, &args)
+
+	var developerErr DeveloperError
+	if !errors.As(err, &developerErr) {
+		t.Fatalf("expected DeveloperError, got %v", err)
+	}
+}
+
+func TestSliceDefault(t *testing.T) {
+	type Args struct {
+		Tags   []string `clap:"default='a,b'"`
+		Ports  []int    `clap:"default=80,default=443"`
+		Hosts  []string `clap:"short=H,sep=;,default='x,y;z'"`
+		Files  []string `clap:"positional,default=-"`
+		Levels []int    `clap:"short=L,sep=:,default=1:2"`
+	}
+
+	args := Args{}
+	if err := New().ParseArgs([]string{"-t", "c", "-L", "3"}, &args); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(args.Tags, []string{"c"}) {
+		t.Fatalf("expected default to be replaced, got %+v", args.Tags)
+	}
+	if !reflect.DeepEqual(args.Ports, []int{80, 443}) {
+		t.Fatalf("unexpected slice values: %+v", args.Ports)
+	}
+	if !reflect.DeepEqual(args.Hosts, []string{"x,y", "z"}) {
+		t.Fatalf("unexpected slice values: %+v", args.Hosts)
+	}
+	if !reflect.DeepEqual(args.Files, []string{"-"}) {
+		t.Fatalf("unexpected slice values: %+v", args.Files)
+	}
+	if !reflect.DeepEqual(args.Levels, []int{3}) {
+		t.Fatalf("expected default to be replaced, got %+v", args.Levels)
+	}
+}
+
+func TestSliceDefaultHelp(t *testing.T) {
+	type Args struct {
+		Tags []string `clap:"default=a,default=b"`
+	}
+
+	stdout := bytes.Buffer{}
+	args := Args{}
+	err := New(WithStdout(&stdout)).ParseArgs([]string{"--help"}, &args)
+	if !errors.Is(err, ErrHelp) {
+		t.Fatalf("expected ErrHelp, got %v", err)
+	}
+
+	if !strings.Contains(stdout.String(), "default: a, b") {
+		t.Fatalf("unexpected help:\n%s", stdout.String())
+	}
+}
+
+func TestMultipleDefaultsNotSliceFail(t *testing.T) {
+	type Args struct {
+		Name string `clap:"default=a,default=b"`
+	}
+
+	args := Args{}
+	err := New().ParseArgs([]string{}, &args)
+
+	var developerErr DeveloperError
+	if !errors.As(err, &developerErr) {
+		t.Fatalf("expected DeveloperError, got %v", err)
+	}
+}
+
+func TestNegativeNumbers(t *testing.T) {
+	type Args struct {
+		Offset int
+		Scale  float64
+		Values []float64 `clap:"positional"`
+	}
+
+	args := Args{}
+	if err := New().ParseArgs([]string{"--offset", "-5", "--scale", "-.25", "-1", "2", "-3.5"}, &args); err != nil {
+		t.Fatal(err)
+	}
+
+	if args.Offset != -5 || args.Scale != -0.25 {
+		t.Fatalf("unexpected values: %+v", args)
+	}
+	if !reflect.DeepEqual(args.Values, []float64{-1, 2, -3.5}) {
+		t.Fatalf("unexpected slice values: %+v", args.Values)
+	}
+}
+
+func TestNegativeNumbersWithDigitShortName(t *testing.T) {
+	type Args struct {
+		One    bool `clap:"short=1"`
+		Number int  `clap:"positional"`
+	}
+
+	args := Args{}
+	if err := New().ParseArgs([]string{"-1"}, &args); err != nil {
+		t.Fatal(err)
+	}
+
+	if !args.One || args.Number != 0 {
+		t.Fatalf("unexpected values: %+v", args)
+	}
+}
+
+func TestNegativeNumberForStringIsOption(t *testing.T) {
+	type Args struct {
+		Name string
+	}
+
+	args := Args{}
+	err := New().ParseArgs([]string{"--name", "-5"}, &args)
+
+	var missingErr MissingValueError
+	if !errors.As(err, &missingErr) {
+		t.Fatalf("expected MissingValueError, got %v", err)
+	}
+}
+
+func writeFile(t *testing.T, name string, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestResponseFiles(t *testing.T) {
+	type Args struct {
+		Name  string
+		Files []string `clap:"positional"`
+	}
+
+	nested := writeFile(t, "nested.txt", "c.txt\n")
+	path := writeFile(t, "args.txt", "# the name\n--name 'Alice Doe'\n\"b \\\"1\\\".txt\" @"+nested+" # trailing comment\n")
+
+	args := Args{}
+	if err := New(WithResponseFiles()).ParseArgs([]string{"a.txt", "@" + path, "@@d.txt", "--", "@e.txt"}, &args); err != nil {
+		t.Fatal(err)
+	}
+
+	if args.Name != "Alice Doe" {
+		t.Fatalf("expected 'Alice Doe', got '%s'", args.Name)
+	}
+	if !reflect.DeepEqual(args.Files, []string{"a.txt", "b \"1\".txt", "c.txt", "@d.txt", "@e.txt"}) {
+		t.Fatalf("unexpected slice values: %q", args.Files)
+	}
+}
+
+func TestResponseFilesDisabledByDefault(t *testing.T) {
+	type Args struct {
+		Files []string `clap:"positional"`
+	}
+
+	args := Args{}
+	if err := New().ParseArgs([]string{"@args.txt"}, &args); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(args.Files, []string{"@args.txt"}) {
+		t.Fatalf("unexpected slice values: %q", args.Files)
+	}
+}
+
+func TestResponseFileErrors(t *testing.T) {
+	type Args struct {
+		Files []string `clap:"positional"`
+	}
+
+	path := writeFile(t, "args.txt", "a.txt\n'b.txt\n")
+
+	args := Args{}
+	err := New(WithResponseFiles()).ParseArgs([]string{"@" + path}, &args)
+
+	var fileErr ResponseFileError
+	if !errors.As(err, &fileErr) {
+		t.Fatalf("expected ResponseFileError, got %v", err)
+	}
+	if fileErr.File != path || fileErr.Line != 2 {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	recursive := filepath.Join(t.TempDir(), "recursive.txt")
+	if err := os.WriteFile(recursive, []byte("@"+recursive), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	err = New(WithResponseFiles()).ParseArgs([]string{"@" + recursive}, &args)
+	if !errors.As(err, &fileErr) || fileErr.Line != 1 {
+		t.Fatalf("expected ResponseFileError, got %v", err)
+	}
+
+	err = New(WithResponseFiles()).ParseArgs([]string{"@does-not-exist.txt"}, &args)
+	if !errors.As(err, &fileErr) || fileErr.File != "does-not-exist.txt" {
+		t.Fatalf("expected ResponseFileError, got %v", err)
+	}
+}
+
+func TestAliases(t *testing.T) {
+	type Args struct {
+		Output string `clap:"alias=out,short_alias=O,hidden_alias=outfile"`
+	}
+
+	for _, argv := range [][]string{
+		{"--output", "a.txt"},
+		{"--out", "a.txt"},
+		{"--outfile=a.txt"},
+		{"-o", "a.txt"},
+		{"-Oa.txt"},
+	} {
+		args := Args{}
+		if err := New().ParseArgs(argv, &args); err != nil {
+			t.Fatal(err)
+		}
+		if args.Output != "a.txt" {
+			t.Fatalf("%v: expected 'a.txt', got '%s'", argv, args.Output)
+		}
+	}
+
+	args := Args{}
+	err := New().ParseArgs([]string{"--out", "a.txt", "-o", "b.txt"}, &args)
+
+	var multipleErr MultipleUseError
+	if !errors.As(err, &multipleErr) {
+		t.Fatalf("expected MultipleUseError, got %v", err)
+	}
+}
+
+func TestAliasesHelp(t *testing.T) {
+	type Args struct {
+		Output string `clap:"alias=out,short_alias=O,hidden_alias=outfile"`
+	}
+
+	stdout := bytes.Buffer{}
+	args := Args{}
+	err := New(WithStdout(&stdout)).ParseArgs([]string{"--help"}, &args)
+	if !errors.Is(err, ErrHelp) {
+		t.Fatalf("expected ErrHelp, got %v", err)
+	}
+
+	help := stdout.String()
+	if !strings.Contains(help, "-o, -O, --output, --out <Output>") || strings.Contains(help, "outfile") {
+		t.Fatalf("unexpected help:\n%s", help)
+	}
+}
+
+func TestAliasCollision(t *testing.T) {
+	type Args struct {
+		Output string `clap:"alias=out"`
+		Out    string `clap:"short=O"`
+	}
+
+	args := Args{}
+	err := New().ParseArgs([]string{}, &args)
+
+	var developerErr DeveloperError
+	if !errors.As(err, &developerErr) {
+		t.Fatalf("expected DeveloperError, got %v", err)
+	}
+}
+
+func TestHiddenOption(t *testing.T) {
+	type Args struct {
+		Name   string
+		Secret string `clap:"hidden,desc='The secret'"`
+	}
+
+	args := Args{}
+	if err := New().ParseArgs([]string{"--secret", "xyz"}, &args); err != nil {
+		t.Fatal(err)
+	}
+	if args.Secret != "xyz" {
+		t.Fatalf("expected 'xyz', got '%s'", args.Secret)
+	}
+
+	stdout := bytes.Buffer{}
+	if err := New(WithStdout(&stdout)).ParseArgs([]string{"--help"}, &args); !errors.Is(err, ErrHelp) {
+		t.Fatalf("expected ErrHelp, got %v", err)
+	}
+	if strings.Contains(stdout.String(), "--secret") || !strings.Contains(stdout.String(), "--help-all") {
+		t.Fatalf("unexpected help:\n%s", stdout.String())
+	}
+
+	stdout.Reset()
+	if err := New(WithStdout(&stdout)).ParseArgs([]string{"--help-all"}, &args); !errors.Is(err, ErrHelp) {
+		t.Fatalf("expected ErrHelp, got %v", err)
+	}
+	if !strings.Contains(stdout.String(), "--secret") {
+		t.Fatalf("unexpected help:\n%s", stdout.String())
+	}
+}
+
+func TestDeprecatedOption(t *testing.T) {
+	type Args struct {
+		Old string `clap:"deprecated='use --new instead'"`
+		New string
+	}
+
+	stderr := bytes.Buffer{}
+	args := Args{}
+	if err := New(WithStderr(&stderr)).ParseArgs([]string{"--old", "xyz"}, &args); err != nil {
+		t.Fatal(err)
+	}
+
+	if args.Old != "xyz" {
+		t.Fatalf("expected 'xyz', got '%s'", args.Old)
+	}
+	if stderr.String() != "warning: --old is deprecated: use --new instead\n" {
+		t.Fatalf("unexpected warning: %s", stderr.String())
+	}
+}
+
+func TestTrailingPositional(t *testing.T) {
+	type Args struct {
+		Verbose bool
+		Command string   `clap:"positional,mandatory"`
+		Args    []string `clap:"positional,trailing"`
+	}
+
+	args := Args{}
+	if err := New().ParseArgs([]string{"-v", "ls", "-la", "--", "--color"}, &args); err != nil {
+		t.Fatal(err)
+	}
+
+	if !args.Verbose || args.Command != "ls" {
+		t.Fatalf("unexpected values: %+v", args)
+	}
+	if !reflect.DeepEqual(args.Args, []string{"-la", "--", "--color"}) {
+		t.Fatalf("unexpected slice values: %q", args.Args)
+	}
+}
+
+func TestPosixMode(t *testing.T) {
+	type Args struct {
+		Verbose bool
+		Files   []string `clap:"positional"`
+	}
+
+	args := Args{}
+	if err := New(WithPosix(PosixOn)).ParseArgs([]string{"-v", "a.txt", "-v"}, &args); err != nil {
+		t.Fatal(err)
+	}
+	if !args.Verbose || !reflect.DeepEqual(args.Files, []string{"a.txt", "-v"}) {
+		t.Fatalf("unexpected values: %+v", args)
+	}
+
+	args = Args{}
+	if err := New().ParseArgs([]string{"a.txt", "-v"}, &args); err != nil {
+		t.Fatal(err)
+	}
+	if !args.Verbose || !reflect.DeepEqual(args.Files, []string{"a.txt"}) {
+		t.Fatalf("unexpected values: %+v", args)
+	}
+}
+
+func TestPosixModeAuto(t *testing.T) {
+	type Args struct {
+		Verbose bool
+		Files   []string `clap:"positional"`
+	}
+
+	t.Setenv("POSIXLY_CORRECT", "1")
+
+	args := Args{}
+	if err := New(WithPosix(PosixAuto)).ParseArgs([]string{"a.txt", "-v"}, &args); err != nil {
+		t.Fatal(err)
+	}
+	if args.Verbose || !reflect.DeepEqual(args.Files, []string{"a.txt", "-v"}) {
+		t.Fatalf("unexpected values: %+v", args)
+	}
+}
+
+func TestMapArg(t *testing.T) {
+	type Args struct {
+		Define map[string]string `clap:"short=D"`
+		Limit  map[string]int    `clap:"sep=','"`
+	}
+
+	args := Args{}
+	if err := New().ParseArgs([]string{"-D", "a=1", "-Db=x=y", "--define", "a=2", "--limit", "cpu=2,mem=512"}, &args); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(args.Define, map[string]string{"a": "2", "b": "x=y"}) {
+		t.Fatalf("unexpected define: %v", args.Define)
+	}
+	if !reflect.DeepEqual(args.Limit, map[string]int{"cpu": 2, "mem": 512}) {
+		t.Fatalf("unexpected limit: %v", args.Limit)
+	}
+
+	err := New().ParseArgs([]string{"--limit", "cpu"}, &Args{})
+	var invalidValueErr InvalidValueError
+	if !errors.As(err, &invalidValueErr) || invalidValueErr.Value != "cpu" {
+		t.Fatalf("expected InvalidValueError, got %v", err)
+	}
+}
+
+func TestMapUniqueKeys(t *testing.T) {
+	type Args struct {
+		Env map[string]string `clap:"unique_keys"`
+	}
+
+	err := New().ParseArgs([]string{"--env", "a=1", "--env", "a=2"}, &Args{})
+	var invalidValueErr InvalidValueError
+	if !errors.As(err, &invalidValueErr) || err.Error() != "invalid value for --env: duplicate key: a" {
+		t.Fatalf("expected duplicate key error, got %v", err)
+	}
+}
+
+func TestMapDefault(t *testing.T) {
+	type Args struct {
+		Env map[string]string `clap:"default=a=1,default=b=2"`
+	}
+
+	args := Args{}
+	if err := New().ParseArgs([]string{}, &args); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(args.Env, map[string]string{"a": "1", "b": "2"}) {
+		t.Fatalf("unexpected env: %v", args.Env)
+	}
+
+	args = Args{}
+	if err := New().ParseArgs([]string{"--env", "c=3"}, &args); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(args.Env, map[string]string{"c": "3"}) {
+		t.Fatalf("unexpected env: %v", args.Env)
+	}
+}
+
+func TestIntegerAndFloatWidths(t *testing.T) {
+	type Args struct {
+		Small  int8
+		Large  int64
+		Port   uint16
+		Mask   uint32
+		Ratio  float32
+		Sizes  []uint8 `clap:"short=z,sep=','"`
+		Offset int16   `clap:"positional"`
+	}
+
+	args := Args{}
+	err := New().ParseArgs([]string{"--small", "-128", "--large", "1_000_000", "--port", "0x1F90", "--mask", "0b1010", "--ratio", "0.5", "--sizes", "1,0o17,255", "--", "-010"}, &args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Args{Small: -128, Large: 1000000, Port: 8080, Mask: 10, Ratio: 0.5, Sizes: []uint8{1, 15, 255}, Offset: -10}
+	if !reflect.DeepEqual(args, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, args)
+	}
+}
+
+func TestIntegerOutOfRange(t *testing.T) {
+	type Args struct {
+		Port  uint16
+		Small int8
+		Ratio float32
+		Sizes []uint8 `clap:"short=z"`
+	}
+
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"--port", "70000"}, "invalid value for --port: value 70000 out of range for uint16"},
+		{[]string{"--port", "-1"}, "invalid value for --port: value -1 out of range for uint16"},
+		{[]string{"--small", "128"}, "invalid value for --small: value 128 out of range for int8"},
+		{[]string{"--ratio", "1e39"}, "invalid value for --ratio: value 1e39 out of range for float32"},
+		{[]string{"--sizes", "256"}, "invalid value for --sizes: value 256 out of range for uint8"},
+		{[]string{"--port", "abc"}, "invalid value for --port: value is not an unsigned int: abc"},
+	}
+	for _, test := range tests {
+		err := New().ParseArgs(test.args, &Args{})
+		var invalidValueErr InvalidValueError
+		if !errors.As(err, &invalidValueErr) || err.Error() != test.expected {
+			t.Errorf("%v: expected %q, got %v", test.args, test.expected, err)
+		}
+	}
+}
+
+func TestTextUnmarshaler(t *testing.T) {
+	type Args struct {
+		Level  slog.Level   `clap:"default=warn"`
+		Allow  []netip.Addr `clap:"sep=','"`
+		Listen netip.Addr   `clap:"positional"`
+	}
+
+	args := Args{}
+	if err := New().ParseArgs([]string{"--allow", "10.0.0.1,::1", "127.0.0.1"}, &args); err != nil {
+		t.Fatal(err)
+	}
+	expected := Args{
+		Level:  slog.LevelWarn,
+		Allow:  []netip.Addr{netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("::1")},
+		Listen: netip.MustParseAddr("127.0.0.1"),
+	}
+	if !reflect.DeepEqual(args, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, args)
+	}
+
+	err := New().ParseArgs([]string{"--level", "loud", "127.0.0.1"}, &Args{})
+	var invalidValueErr InvalidValueError
+	if !errors.As(err, &invalidValueErr) || invalidValueErr.Field != "Level" {
+		t.Fatalf("expected InvalidValueError, got %v", err)
+	}
+}
+
+func TestTextMarshalerDefaultInHelp(t *testing.T) {
+	type Args struct {
+		Level slog.Level `clap:"default=warn"`
+	}
+
+	stdout := bytes.Buffer{}
+	err := New(WithStdout(&stdout)).ParseArgs([]string{"--help"}, &Args{})
+	if !errors.Is(err, ErrHelp) {
+		t.Fatalf("expected ErrHelp, got %v", err)
+	}
+	if !strings.Contains(stdout.String(), "default: WARN") {
+		t.Fatalf("expected rendered default in help:\n%s", stdout.String())
+	}
+}
+
+type listValue []string
+
+func (l *listValue) Set(s string) error {
+	if s == "" {
+		return errors.New("empty item")
+	}
+	*l = append(*l, s)
+	return nil
+}
+
+func (l *listValue) String() string { return strings.Join(*l, ",") }
+
+func (l *listValue) Type() string { return "item" }
+
+type traceValue struct{ enabled bool }
+
+func (v *traceValue) Set(s string) error {
+	enabled, err := strconv.ParseBool(s)
+	v.enabled = enabled
+	return err
+}
+
+func (v *traceValue) String() string { return strconv.FormatBool(v.enabled) }
+
+func (v *traceValue) IsBool() bool { return true }
+
+func TestValue(t *testing.T) {
+	type Args struct {
+		Item  listValue  `clap:"short=i"`
+		Trace traceValue `clap:"short=t"`
+		Files []string   `clap:"positional"`
+	}
+
+	var _ flag.Value = (*listValue)(nil)
+
+	args := Args{}
+	if err := New().ParseArgs([]string{"-t", "--item", "a", "-ib", "file.txt"}, &args); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(args.Item, listValue{"a", "b"}) || !args.Trace.enabled || !reflect.DeepEqual(args.Files, []string{"file.txt"}) {
+		t.Fatalf("unexpected values: %+v", args)
+	}
+
+	args = Args{}
+	if err := New().ParseArgs([]string{"--trace=false"}, &args); err != nil {
+		t.Fatal(err)
+	}
+	if args.Trace.enabled {
+		t.Fatalf("expected trace to be disabled")
+	}
+
+	err := New().ParseArgs([]string{"--item="}, &Args{})
+	var invalidValueErr InvalidValueError
+	if !errors.As(err, &invalidValueErr) || invalidValueErr.Field != "Item" {
+		t.Fatalf("expected InvalidValueError, got %v", err)
+	}
+
+	stdout := bytes.Buffer{}
+	err = New(WithStdout(&stdout)).ParseArgs([]string{"--help"}, &Args{})
+	if !errors.Is(err, ErrHelp) {
+		t.Fatalf("expected ErrHelp, got %v", err)
+	}
+	if !strings.Contains(stdout.String(), "--item <item>") {
+		t.Fatalf("expected value type in help:\n%s", stdout.String())
+	}
+}
+
+func TestPointerArgs(t *testing.T) {
+	type Args struct {
+		Salary  *int
+		Name    *string
+		Verbose *bool
+		Timeout *time.Duration `clap:"default=5s"`
+		Addr    *netip.Addr
+		Level   *slog.Level
+		File    *string `clap:"positional"`
+	}
+
+	args := Args{}
+	if err := New().ParseArgs([]string{"--salary", "0", "--verbose"}, &args); err != nil {
+		t.Fatal(err)
+	}
+	if args.Salary == nil || *args.Salary != 0 {
+		t.Fatalf("expected salary to be set to 0, got %v", args.Salary)
+	}
+	if args.Verbose == nil || !*args.Verbose {
+		t.Fatalf("expected verbose to be set, got %v", args.Verbose)
+	}
+	if args.Timeout == nil || *args.Timeout != 5*time.Second {
+		t.Fatalf("expected default timeout, got %v", args.Timeout)
+	}
+	if args.Name != nil || args.Addr != nil || args.Level != nil || args.File != nil {
+		t.Fatalf("expected absent arguments to be nil: %+v", args)
+	}
+
+	args = Args{}
+	if err := New().ParseArgs([]string{"--name", "", "--addr", "::1", "--level", "debug", "out.txt"}, &args); err != nil {
+		t.Fatal(err)
+	}
+	if args.Name == nil || *args.Name != "" {
+		t.Fatalf("expected name to be set to empty string, got %v", args.Name)
+	}
+	if args.Addr == nil || *args.Addr != netip.MustParseAddr("::1") {
+		t.Fatalf("unexpected addr: %v", args.Addr)
+	}
+	if args.Level == nil || *args.Level != slog.LevelDebug {
+		t.Fatalf("unexpected level: %v", args.Level)
+	}
+	if args.File == nil || *args.File != "out.txt" {
+		t.Fatalf("unexpected file: %v", args.File)
+	}
+}
+
+type outputFormat string
+
+func (outputFormat) Choices() []string { return []string{"json", "yaml", "table"} }
+
+func TestChoices(t *testing.T) {
+	type Args struct {
+		Format outputFormat
+		Level  int      `clap:"choices=1|2|3,default=1"`
+		Tags   []string `clap:"choices=a|b,sep=','"`
+	}
+
+	args := Args{}
+	if err := New().ParseArgs([]string{"--format", "yaml", "--level", "3", "--tags", "a,b"}, &args); err != nil {
+		t.Fatal(err)
+	}
+	if args.Format != "yaml" || args.Level != 3 || !reflect.DeepEqual(args.Tags, []string{"a", "b"}) {
+		t.Fatalf("unexpected values: %+v", args)
+	}
+
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"--format", "xml"}, "invalid value 'xml' for --format, expected one of: json, yaml, table"},
+		{[]string{"--format", "jsno"}, "invalid value 'jsno' for --format, expected one of: json, yaml, table, did you mean 'json'?"},
+		{[]string{"-l", "4"}, "invalid value '4' for -l, expected one of: 1, 2, 3"},
+		{[]string{"--tags", "a,c"}, "invalid value 'c' for --tags, expected one of: a, b"},
+	}
+	for _, test := range tests {
+		err := New().ParseArgs(test.args, &Args{})
+		var choiceErr InvalidChoiceError
+		if !errors.As(err, &choiceErr) || err.Error() != test.expected {
+			t.Errorf("%v: expected %q, got %v", test.args, test.expected, err)
+		}
+	}
+
+	stdout := bytes.Buffer{}
+	err := New(WithStdout(&stdout)).ParseArgs([]string{"--help"}, &Args{})
+	if !errors.Is(err, ErrHelp) {
+		t.Fatalf("expected ErrHelp, got %v", err)
+	}
+	if !strings.Contains(stdout.String(), "one of: json, yaml, table") {
+		t.Fatalf("expected choices in help:\n%s", stdout.String())
+	}
+}
+
+func TestTimeArg(t *testing.T) {
+	type Args struct {
+		At    time.Time
+		Day   time.Time   `clap:"layout=date,tz=UTC"`
+		Epoch time.Time   `clap:"layout=unix"`
+		Since *time.Time  `clap:"short=S"`
+		Dates []time.Time `clap:"short=D,layout='Jan 2, 2006',tz=UTC"`
+	}
+
+	args := Args{}
+	before := time.Now()
+	err := New().ParseArgs([]string{"--at", "2024-05-01T12:00:00+02:00", "--day", "2024-05-01", "--epoch", "0", "--since", "-2h", "-D", "Mar 3, 2024"}, &args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !args.At.Equal(time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected at: %v", args.At)
+	}
+	if args.Day != time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC) {
+		t.Fatalf("unexpected day: %v", args.Day)
+	}
+	if !args.Epoch.Equal(time.Unix(0, 0)) {
+		t.Fatalf("unexpected epoch: %v", args.Epoch)
+	}
+	if args.Since == nil || args.Since.Before(before.Add(-2*time.Hour)) || args.Since.After(time.Now().Add(-2*time.Hour)) {
+		t.Fatalf("unexpected since: %v", args.Since)
+	}
+	if !reflect.DeepEqual(args.Dates, []time.Time{time.Date(2024, 3, 3, 0, 0, 0, 0, time.UTC)}) {
+		t.Fatalf("unexpected dates: %v", args.Dates)
+	}
+
+	args = Args{}
+	if err := New().ParseArgs([]string{"--day", "today"}, &args); err != nil {
+		t.Fatal(err)
+	}
+	year, month, day := time.Now().UTC().Date()
+	if args.Day != time.Date(year, month, day, 0, 0, 0, 0, time.UTC) {
+		t.Fatalf("unexpected day: %v", args.Day)
+	}
+}
+
+func TestTimeArgInvalid(t *testing.T) {
+	type Args struct {
+		Day time.Time `clap:"layout=date"`
+	}
+
+	err := New().ParseArgs([]string{"--day", "01.05.2024"}, &Args{})
+	var invalidValueErr InvalidValueError
+	if !errors.As(err, &invalidValueErr) || err.Error() != "invalid value for --day: value is not a time: 01.05.2024, expected layout 2006-01-02" {
+		t.Fatalf("expected InvalidValueError, got %v", err)
+	}
+}
+
+func TestTextUnmarshalerWithSliceKind(t *testing.T) {
+	type Args struct {
+		IP   net.IP `clap:"default=127.0.0.1"`
+		Peer net.IP `clap:"positional"`
+		Name string `clap:"positional"`
+	}
+
+	args := Args{}
+	if err := New().ParseArgs([]string{"10.0.0.1", "foo"}, &args); err != nil {
+		t.Fatal(err)
+	}
+	if !args.IP.Equal(net.ParseIP("127.0.0.1")) || !args.Peer.Equal(net.ParseIP("10.0.0.1")) || args.Name != "foo" {
+		t.Fatalf("unexpected values: %+v", args)
+	}
+
+	err := New().ParseArgs([]string{"--ip", "1.2.3.4", "--ip", "5.6.7.8", "10.0.0.1", "foo"}, &Args{})
+	var multipleUseErr MultipleUseError
+	if !errors.As(err, &multipleUseErr) {
+		t.Fatalf("expected MultipleUseError, got %v", err)
+	}
+
+	stdout := bytes.Buffer{}
+	err = New(WithStdout(&stdout)).ParseArgs([]string{"--help"}, &Args{})
+	if !errors.Is(err, ErrHelp) {
+		t.Fatalf("expected ErrHelp, got %v", err)
+	}
+	if strings.Contains(stdout.String(), "multiple times") {
+		t.Fatalf("expected net.IP not to be listed as a slice:\n%s", stdout.String())
+	}
+}
+
+type envKey string
+
+func TestMapNamedKeyType(t *testing.T) {
+	type Args struct {
+		Env map[envKey]string `clap:"unique_keys"`
+	}
+
+	args := Args{}
+	if err := New().ParseArgs([]string{"--env", "a=1", "--env", "b=2"}, &args); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(args.Env, map[envKey]string{"a": "1", "b": "2"}) {
+		t.Fatalf("unexpected env: %v", args.Env)
+	}
+
+	err := New().ParseArgs([]string{"--env", "a=1", "--env", "a=2"}, &Args{})
+	if err == nil || err.Error() != "invalid value for --env: duplicate key: a" {
+		t.Fatalf("expected duplicate key error, got %v", err)
+	}
+}
+
+type prefixValue []string
+
+func (p *prefixValue) Set(s string) error {
+	*p = append(*p, "x-"+s)
+	return nil
+}
+
+func (p *prefixValue) String() string { return strings.Join(*p, ",") }
+
+func TestValueWithSliceKindDefault(t *testing.T) {
+	type Args struct {
+		Header prefixValue `clap:"short=H,default=a"`
+	}
+
+	args := Args{}
+	if err := New().ParseArgs([]string{}, &args); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(args.Header, prefixValue{"x-a"}) {
+		t.Fatalf("expected default to go through Set, got %v", args.Header)
+	}
+
+	args = Args{}
+	if err := New().ParseArgs([]string{"--header", "b", "--header", "c"}, &args); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(args.Header, prefixValue{"x-b", "x-c"}) {
+		t.Fatalf("unexpected header: %v", args.Header)
+	}
+}
+
+func TestDigitShortAliasDisablesNegativeNumbers(t *testing.T) {
+	type Args struct {
+		One    bool `clap:"short_alias=1"`
+		Number int  `clap:"positional"`
+	}
+
+	args := Args{}
+	if err := New().ParseArgs([]string{"-1", "5"}, &args); err != nil {
+		t.Fatal(err)
+	}
+	if !args.One || args.Number != 5 {
+		t.Fatalf("unexpected values: %+v", args)
+	}
+}
+
+func TestAbbreviationsWithHiddenOption(t *testing.T) {
+	type Args struct {
+		Verbose  bool
+		Verbatim bool   `clap:"short=x,hidden"`
+		Output   string `clap:"hidden_alias=output-file"`
+	}
+
+	args := Args{}
+	if err := New(WithAbbreviations()).ParseArgs([]string{"--verb", "--out", "a.txt"}, &args); err != nil {
+		t.Fatal(err)
+	}
+	if !args.Verbose || args.Verbatim || args.Output != "a.txt" {
+		t.Fatalf("unexpected values: %+v", args)
+	}
+
+	args = Args{}
+	if err := New(WithAbbreviations()).ParseArgs([]string{"--verbatim", "--output-file", "b.txt"}, &args); err != nil {
+		t.Fatal(err)
+	}
+	if !args.Verbatim || args.Output != "b.txt" {
+		t.Fatalf("unexpected values: %+v", args)
+	}
+
+	stdout := bytes.Buffer{}
+	err := New(WithAbbreviations(), WithStdout(&stdout)).ParseArgs([]string{"--hel"}, &args)
+	if !errors.Is(err, ErrHelp) {
+		t.Fatalf("expected --hel to resolve to --help, got %v", err)
+	}
+
+	err = New(WithAbbreviations()).ParseArgs([]string{"--verba"}, &Args{})
+	var unknownArgumentErr UnknownArgumentError
+	if !errors.As(err, &unknownArgumentErr) {
+		t.Fatalf("expected UnknownArgumentError, got %v", err)
+	}
+}
+
+func TestIntChoicesComparedByValue(t *testing.T) {
+	type Args struct {
+		Level int    `clap:"choices=1|2|10"`
+		Port  uint16 `clap:"choices=80|443"`
+	}
+
+	for _, value := range []string{"0x1", "01", "1_0"} {
+		if err := New().ParseArgs([]string{"--level", value}, &Args{}); err != nil {
+			t.Errorf("%s: %v", value, err)
+		}
+	}
+
+	args := Args{}
+	if err := New().ParseArgs([]string{"--port", "0x50"}, &args); err != nil {
+		t.Fatal(err)
+	}
+	if args.Port != 80 {
+		t.Fatalf("unexpected port: %d", args.Port)
+	}
+
+	err := New().ParseArgs([]string{"--level", "0x3"}, &Args{})
+	var choiceErr InvalidChoiceError
+	if !errors.As(err, &choiceErr) {
+		t.Fatalf("expected InvalidChoiceError, got %v", err)
+	}
+}
+
+func TestPointerValueStaysNilOnError(t *testing.T) {
+	type Args struct {
+		Item *listValue
+	}
+
+	args := Args{}
+	err := New().ParseArgs([]string{"--item="}, &args)
+	var invalidValueErr InvalidValueError
+	if !errors.As(err, &invalidValueErr) {
+		t.Fatalf("expected InvalidValueError, got %v", err)
+	}
+	if args.Item != nil {
+		t.Fatalf("expected item to stay nil, got %v", *args.Item)
+	}
+
+	args = Args{}
+	if err := New().ParseArgs([]string{"--item", "a", "--item", "b"}, &args); err != nil {
+		t.Fatal(err)
+	}
+	if args.Item == nil || !reflect.DeepEqual(*args.Item, listValue{"a", "b"}) {
+		t.Fatalf("unexpected item: %v", args.Item)
+	}
+}
+
+func TestOptionalValueContainingEquals(t *testing.T) {
+	type Args struct {
+		Define string `clap:"optional_value=a=b"`
+	}
+
+	args := Args{}
+	if err := New().ParseArgs([]string{"--define"}, &args); err != nil {
+		t.Fatal(err)
+	}
+	if args.Define != "a=b" {
+		t.Fatalf("expected a=b, got %q", args.Define)
+	}
+}
+
+type formatValue string
+
+func (f *formatValue) Set(s string) error {
+	if s != "json" && s != "text" {
+		return errors.New("unsupported format")
+	}
+	*f = formatValue("format:" + s)
+	return nil
+}
+
+func (f *formatValue) String() string { return string(*f) }
+
+type switchValue bool
+
+func (v *switchValue) Set(s string) error {
+	on := s == "on"
+	if !on && s != "off" {
+		return errors.New("expected on or off")
+	}
+	*v = switchValue(on)
+	return nil
+}
+
+func (v *switchValue) String() string {
+	if *v {
+		return "on"
+	}
+	return "off"
+}
+
+func TestValueWithStringAndBoolKind(t *testing.T) {
+	type Args struct {
+		Format formatValue `clap:"default=text"`
+		Cache  switchValue
+		Out    formatValue `clap:"positional"`
+	}
+
+	args := Args{}
+	if err := New().ParseArgs([]string{"--cache", "on", "json"}, &args); err != nil {
+		t.Fatal(err)
+	}
+	if args.Format != "format:text" || !args.Cache || args.Out != "format:json" {
+		t.Fatalf("unexpected values: %+v", args)
+	}
+
+	err := New().ParseArgs([]string{"--format", "xml", "json"}, &Args{})
+	var invalidValueErr InvalidValueError
+	if !errors.As(err, &invalidValueErr) || invalidValueErr.Field != "Format" {
+		t.Fatalf("expected InvalidValueError, got %v", err)
+	}
+
+	err = New().ParseArgs([]string{"--cache"}, &Args{})
+	var missingValueErr MissingValueError
+	if !errors.As(err, &missingValueErr) {
+		t.Fatalf("expected MissingValueError, got %v", err)
+	}
+}
+
+type upperText string
+
+func (u *upperText) UnmarshalText(text []byte) error {
+	*u = upperText(strings.ToUpper(string(text)))
+	return nil
+}
+
+func TestTextUnmarshalerWithStringKind(t *testing.T) {
+	type Args struct {
+		Upper upperText
+		Name  upperText `clap:"positional"`
+	}
+
+	args := Args{}
+	if err := New().ParseArgs([]string{"-u", "abc", "def"}, &args); err != nil {
+		t.Fatal(err)
+	}
+	if args.Upper != "ABC" || args.Name != "DEF" {
+		t.Fatalf("unexpected values: %+v", args)
+	}
+}
+
+func TestHelpAllOnlyWithHiddenArgs(t *testing.T) {
+	type Args struct {
+		HelpAll bool `clap:"short=z"`
+	}
+
+	args := Args{}
+	if err := New().ParseArgs([]string{"--help-all"}, &args); err != nil {
+		t.Fatal(err)
+	}
+	if !args.HelpAll {
+		t.Fatalf("expected HelpAll field to be set")
+	}
+}
+
+func TestHelpAllRevealsHiddenAliases(t *testing.T) {
+	type Args struct {
+		Output string `clap:"hidden_alias=out-file"`
+	}
+
+	stdout := bytes.Buffer{}
+	err := New(WithStdout(&stdout)).ParseArgs([]string{"--help"}, &Args{})
+	if !errors.Is(err, ErrHelp) {
+		t.Fatalf("expected ErrHelp, got %v", err)
+	}
+	if strings.Contains(stdout.String(), "--out-file") || !strings.Contains(stdout.String(), "--help-all") {
+		t.Fatalf("expected hidden alias to be hidden and --help-all to be listed:\n%s", stdout.String())
+	}
+
+	stdout.Reset()
+	err = New(WithStdout(&stdout)).ParseArgs([]string{"--help-all"}, &Args{})
+	if !errors.Is(err, ErrHelp) {
+		t.Fatalf("expected ErrHelp, got %v", err)
+	}
+	if !strings.Contains(stdout.String(), "--out-file") {
+		t.Fatalf("expected hidden alias to be revealed:\n%s", stdout.String())
+	}
+}
+
+func TestAbbreviationsWithAliases(t *testing.T) {
+	type Args struct {
+		Color   string `clap:"alias=colour"`
+		Columns int    `clap:"short=n"`
+	}
+
+	args := Args{}
+	if err := New(WithAbbreviations()).ParseArgs([]string{"--colo", "red"}, &args); err != nil {
+		t.Fatal(err)
+	}
+	if args.Color != "red" {
+		t.Fatalf("unexpected values: %+v", args)
+	}
+
+	err := New(WithAbbreviations()).ParseArgs([]string{"--col", "red"}, &Args{})
+	var ambiguousErr AmbiguousArgumentError
+	if !errors.As(err, &ambiguousErr) || !reflect.DeepEqual(ambiguousErr.Candidates, []string{"--color", "--columns"}) {
+		t.Fatalf("expected AmbiguousArgumentError, got %v", err)
+	}
+}
+
+func TestNestedResponseFileNotFound(t *testing.T) {
+	type Args struct {
+		Files []string `clap:"positional"`
+	}
+
+	missing := filepath.Join(t.TempDir(), "missing.txt")
+	path := writeFile(t, "outer.txt", "a.txt\nb.txt\n@"+missing+"\n")
+
+	err := New(WithResponseFiles()).ParseArgs([]string{"@" + path}, &Args{})
+	var fileErr ResponseFileError
+	if !errors.As(err, &fileErr) || fileErr.File != path || fileErr.Line != 3 {
+		t.Fatalf("expected ResponseFileError in %s at line 3, got %v", path, err)
+	}
+	if !strings.Contains(err.Error(), missing) || !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("expected error to name the missing file, got %v", err)
+	}
+}
+
+func TestRepeatedDoubleDash(t *testing.T) {
+	type Args struct {
+		Files []string `clap:"positional"`
+	}
+
+	args := Args{}
+	if err := New().ParseArgs([]string{"--", "x", "--", "y"}, &args); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(args.Files, []string{"x", "y"}) {
+		t.Fatalf("unexpected files: %v", args.Files)
+	}
+
+	args = Args{}
+	if err := New(WithPosix(PosixOn)).ParseArgs([]string{"x", "--", "y"}, &args); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(args.Files, []string{"x", "--", "y"}) {
+		t.Fatalf("unexpected files: %v", args.Files)
+	}
+}
+
+func TestInvalidValueErrorNamesArgument(t *testing.T) {
+	type Args struct {
+		Age   int
+		Count int `clap:"positional"`
+	}
+
+	err := New(WithAllErrors()).ParseArgs([]string{"--age", "x", "y"}, &Args{})
+	expected := "invalid value for --age: value is not an int: x\ninvalid value for Count: value is not an int: y"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("expected %q, got %v", expected, err)
+	}
+}